@@ -19,6 +19,7 @@ function App() {
 
         {/* Public profile */}
         <Route path="/:username" element={<Public />} />
+        <Route path="/:username/:slug" element={<Public />} />
 
         {/* Fallback: redirect home to login */}
         <Route path="/" element={<Navigate to="/admin-login" replace />} />
@@ -158,15 +158,18 @@ const buildResponsiveLayouts = (lgLayout: any[]): any => {
 interface BentoGridProps {
     isEditable: boolean;
     publicUsername?: string;
+    pageSlug?: string;
 }
 
-export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
+export const BentoGrid = ({ isEditable, publicUsername, pageSlug }: BentoGridProps) => {
     const [widgets, setWidgets] = useState<WidgetData[]>([]);
     const [widgetToDelete, setWidgetToDelete] = useState<{ id: string, imageUrl?: string } | null>(null);
     const [layouts, setLayouts] = useState<any>({ lg: [] });
     const [isLoading, setIsLoading] = useState(true);
 
     const isRemoteUpdate = useRef(false);
+    // Page version from the server, sent back on sync so concurrent edits are detected.
+    const versionRef = useRef(0);
 
     const gridBreakpoints = useMemo(() => ({
         lg: 1200,
@@ -231,7 +234,16 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
                 // Fetch Config + Profile info implicitly via backend or separate route
                 // Our backend /bento/:username returns the config object
 
-                const response = await api.get(`/bento/${targetUsername}`);
+                // The editor works on the draft; visitors see the published page,
+                // or the draft when opened through a preview link.
+                const previewToken = new URLSearchParams(window.location.search).get("preview");
+                const response = isEditable
+                    ? await api.get('/bento/draft')
+                    : previewToken
+                        ? await api.get('/bento/preview', { params: { token: previewToken } })
+                        : await api.get(pageSlug ? `/bento/${targetUsername}/${pageSlug}` : `/bento/${targetUsername}`, {
+                            params: document.referrer ? { ref: document.referrer } : undefined,
+                        });
                 const config = response.data;
 
                 // If backend returns 404 or 403, axios throws
@@ -242,6 +254,7 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
                     // For now, let's assume config has username. 
 
                     setConfigDocId(config._id);
+                    versionRef.current = config.version ?? 0;
                     isRemoteUpdate.current = true;
 
                     // Parse if stored as string (mixed type in mongoose might be obj or string depending on save)
@@ -272,7 +285,7 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
         };
 
         loadData();
-    }, [isEditable, publicUsername, currentUserUsername]);
+    }, [isEditable, publicUsername, pageSlug, currentUserUsername]);
 
     // Persistence Effect
     useEffect(() => {
@@ -289,14 +302,25 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
             try {
                 const payload = {
                     widgets: widgets, // Send as object, backend handles it
-                    layouts: layouts
+                    layouts: layouts,
+                    version: versionRef.current
                 };
 
                 const response = await api.post('/bento/sync', payload);
+                versionRef.current = response.data.version ?? versionRef.current;
                 if (response.data._id) {
                     setConfigDocId(response.data._id);
                 }
-            } catch (error) {
+            } catch (error: any) {
+                // Someone else saved first: load their version instead of overwriting it.
+                const current = error.response?.status === 409 ? error.response.data.current : null;
+                if (current) {
+                    versionRef.current = current.version ?? 0;
+                    isRemoteUpdate.current = true;
+                    setWidgets(current.widgets || []);
+                    setLayouts(current.layouts?.lg ? buildResponsiveLayouts(current.layouts.lg) : (current.layouts || {}));
+                    return;
+                }
                 console.error("Failed to sync data:", error);
             }
         };
@@ -306,6 +330,15 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
     }, [widgets, layouts, isLoading, isEditable, currentUserUsername, configDocId]);
 
 
+    const publishDraft = async () => {
+        try {
+            await api.post('/bento/publish', {}, { headers: { 'If-Match': `"${versionRef.current}"` } });
+            toast.success("Page published");
+        } catch (error: any) {
+            toast.error(error.response?.data?.message || "Failed to publish");
+        }
+    };
+
     // State for Add Widget Modal
     const [isAddModalOpen, setIsAddModalOpen] = useState(false);
     const addWidgetButtonRef = useRef<HTMLButtonElement>(null);
@@ -322,7 +355,9 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
             customTitle: data.customTitle,
             customImage: data.customImage,
             ctaText: data.ctaText,
-            imageFit: data.imageFit
+            imageFit: data.imageFit,
+            visibleFrom: data.visibleFrom,
+            visibleUntil: data.visibleUntil
         };
 
         setWidgets(prev => [...prev, newWidget]);
@@ -604,6 +639,7 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
                                             onEdit={handleEditWidget}
                                             isEditable={isEditable}
                                             ownerUsername={publicUsername || currentUserUsername || undefined}
+                                            pageSlug={pageSlug}
                                         />
                                     </div>
                                 </div>
@@ -612,6 +648,16 @@ export const BentoGrid = ({ isEditable, publicUsername }: BentoGridProps) => {
                     )}
                 </div>
             </div>
+            {/* Publish Button */}
+            {isEditable && !isAddModalOpen && !activeWidget && (
+                <button
+                    onClick={publishDraft}
+                    className="fixed bottom-4 left-4 sm:bottom-8 sm:left-8 z-[100] px-6 h-12 bg-black dark:bg-white text-white dark:text-black rounded-2xl font-bold hover:scale-[1.02] active:scale-[0.98] transition-all shadow-lg"
+                >
+                    Publish
+                </button>
+            )}
+
             {/* Floating Add Button */}
             {isEditable && !isAddModalOpen && !activeWidget && (
                 <motion.button
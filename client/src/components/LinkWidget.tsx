@@ -21,6 +21,8 @@ export interface WidgetData {
     customImage?: string;
     ctaText?: string;
     imageFit?: "cover" | "contain";
+    visibleFrom?: string;
+    visibleUntil?: string;
     isWide?: boolean;
 }
 
@@ -31,12 +33,13 @@ interface LinkWidgetProps {
     onEdit: (data: WidgetData, buttonRef: { current: HTMLElement | null }) => void;
     isEditable?: boolean;
     ownerUsername?: string;
+    pageSlug?: string;
 }
 
 
 
 
-export const LinkWidget = ({ data, onUpdate, onRemove, onEdit, isEditable = false, ownerUsername }: LinkWidgetProps) => {
+export const LinkWidget = ({ data, onUpdate, onRemove, onEdit, isEditable = false, ownerUsername, pageSlug }: LinkWidgetProps) => {
     const [loading, setLoading] = useState(false);
     const [metadata, setMetadata] = useState<LinkMetadata | null>(null);
     const [error, setError] = useState<string | null>(null);
@@ -93,16 +96,16 @@ export const LinkWidget = ({ data, onUpdate, onRemove, onEdit, isEditable = fals
         e.preventDefault();
         e.stopPropagation();
         if (!isDragging && data.url) {
-            // Fire-and-forget click tracking
-            if (ownerUsername) {
-                api.post("/clicks", {
-                    widget_id: data.id,
-                    owner_username: ownerUsername,
-                    url: data.url,
-                    custom_title: data.customTitle || "",
-                    custom_image: data.customImage || "",
-                    referrer: document.referrer || "",
-                }).catch(() => { /* silently ignore */ });
+            // Visitors go through the server redirect, which records the click
+            // even when a blocker would drop a separate tracking request.
+            if (!isEditable && ownerUsername) {
+                const query = new URLSearchParams();
+                if (pageSlug) query.set("page", pageSlug);
+                if (document.referrer) query.set("ref", document.referrer);
+                const search = query.toString() ? `?${query}` : "";
+                const target = `${api.defaults.baseURL}/r/${encodeURIComponent(ownerUsername)}/${encodeURIComponent(data.id)}${search}`;
+                window.open(target, "_blank", "noopener,noreferrer");
+                return;
             }
             window.open(data.url, "_blank", "noopener,noreferrer");
         }
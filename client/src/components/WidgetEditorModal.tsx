@@ -21,12 +21,24 @@ interface WidgetEditorModalProps {
     buttonRef?: React.RefObject<HTMLButtonElement | null>; // Reference to the Add Widget button for genie effect
 }
 
+// datetime-local inputs work in local time without a zone; the API stores ISO timestamps.
+const toLocalInput = (iso?: string) => {
+    if (!iso) return "";
+    const date = new Date(iso);
+    if (isNaN(date.getTime())) return "";
+    return new Date(date.getTime() - date.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+};
+
+const fromLocalInput = (value: string) => (value ? new Date(value).toISOString() : undefined);
+
 export const WidgetEditorModal = ({ isOpen, onClose, onSave, initialData = {}, buttonRef, title }: WidgetEditorModalProps) => {
     // Local edit states
     const [editTitle, setEditTitle] = useState(initialData.customTitle || "");
     const [editUrl, setEditUrl] = useState(initialData.url || "");
     const [editThumbnail, setEditThumbnail] = useState(initialData.customImage || "");
     const [editCtaText, setEditCtaText] = useState(initialData.ctaText || "");
+    const [editVisibleFrom, setEditVisibleFrom] = useState(toLocalInput(initialData.visibleFrom));
+    const [editVisibleUntil, setEditVisibleUntil] = useState(toLocalInput(initialData.visibleUntil));
     const [urlError, setUrlError] = useState("");
     const fileInputRef = useRef<HTMLInputElement>(null);
 
@@ -51,11 +63,13 @@ export const WidgetEditorModal = ({ isOpen, onClose, onSave, initialData = {}, b
             setEditUrl(initialData.url || "");
             setEditThumbnail(initialData.customImage || "");
             setEditCtaText(initialData.ctaText || "");
+            setEditVisibleFrom(toLocalInput(initialData.visibleFrom));
+            setEditVisibleUntil(toLocalInput(initialData.visibleUntil));
             setUrlError("");
         }
 
         prevIsOpenRef.current = isOpen;
-    }, [isOpen, initialData.customTitle, initialData.url, initialData.customImage, initialData.ctaText]);
+    }, [isOpen, initialData.customTitle, initialData.url, initialData.customImage, initialData.ctaText, initialData.visibleFrom, initialData.visibleUntil]);
 
     const onCropComplete = useCallback((_croppedArea: Area, croppedAreaPixels: Area) => {
         setCroppedAreaPixels(croppedAreaPixels);
@@ -275,11 +289,18 @@ export const WidgetEditorModal = ({ isOpen, onClose, onSave, initialData = {}, b
         }
         setUrlError("");
 
+        if (editVisibleFrom && editVisibleUntil && new Date(editVisibleUntil) <= new Date(editVisibleFrom)) {
+            toast.error("Hide time must be after show time");
+            return;
+        }
+
         onSave({
             customTitle: editTitle,
             url: editUrl,
             customImage: editThumbnail,
-            ctaText: editCtaText
+            ctaText: editCtaText,
+            visibleFrom: fromLocalInput(editVisibleFrom),
+            visibleUntil: fromLocalInput(editVisibleUntil)
         });
         onClose();
     };
@@ -484,6 +505,33 @@ export const WidgetEditorModal = ({ isOpen, onClose, onSave, initialData = {}, b
                                         />
                                     </div>
                                 </div>
+
+                                <div className="bg-white dark:bg-black rounded-[18px] shadow-sm ring-1 ring-black/5 dark:ring-white/10 flex flex-col overflow-hidden">
+                                    <div className="px-4 sm:px-5 py-2 border-b border-gray-100 dark:border-white/10">
+                                        <Label htmlFor="visibleFrom" className="text-[11px] font-medium text-gray-500 dark:text-white uppercase tracking-wide">
+                                            Show From
+                                        </Label>
+                                        <Input
+                                            id="visibleFrom"
+                                            type="datetime-local"
+                                            value={editVisibleFrom}
+                                            onChange={(e) => setEditVisibleFrom(e.target.value)}
+                                            className="h-10 -ml-3 w-[calc(100%+1.5rem)] border-none shadow-none focus-visible:ring-0 bg-transparent text-[15px] font-normal text-gray-900 dark:text-white px-3"
+                                        />
+                                    </div>
+                                    <div className="px-4 sm:px-5 py-2">
+                                        <Label htmlFor="visibleUntil" className="text-[11px] font-medium text-gray-500 dark:text-white uppercase tracking-wide">
+                                            Hide After
+                                        </Label>
+                                        <Input
+                                            id="visibleUntil"
+                                            type="datetime-local"
+                                            value={editVisibleUntil}
+                                            onChange={(e) => setEditVisibleUntil(e.target.value)}
+                                            className="h-10 -ml-3 w-[calc(100%+1.5rem)] border-none shadow-none focus-visible:ring-0 bg-transparent text-[15px] font-normal text-gray-900 dark:text-white px-3"
+                                        />
+                                    </div>
+                                </div>
                             </div>
                             </div>
 
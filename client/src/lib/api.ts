@@ -37,4 +37,56 @@ api.interceptors.request.use(
     }
 );
 
+export const saveSession = (data: { token: string; refresh_token?: string }) => {
+    localStorage.setItem('token', data.token);
+    if (data.refresh_token) {
+        localStorage.setItem('refresh_token', data.refresh_token);
+    }
+};
+
+export const clearSession = () => {
+    localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
+};
+
+// Share one in-flight refresh between concurrent 401s, since the server
+// rotates the refresh token and rejects the old one.
+let refreshing: Promise<string> | null = null;
+
+const refreshAccessToken = () => {
+    if (!refreshing) {
+        const refreshToken = localStorage.getItem('refresh_token');
+        refreshing = (refreshToken
+            ? axios.post(`${baseUrl}/auth/refresh`, { refresh_token: refreshToken }).then((response) => {
+                saveSession(response.data);
+                return response.data.token as string;
+            })
+            : Promise.reject(new Error('No refresh token'))
+        ).finally(() => {
+            refreshing = null;
+        });
+    }
+    return refreshing;
+};
+
+// Retry once with a fresh access token when the current one has expired
+api.interceptors.response.use(
+    (response) => response,
+    async (error) => {
+        const original = error.config;
+        if (error.response?.status !== 401 || !original || original._retried || original.url?.startsWith('/auth/refresh')) {
+            return Promise.reject(error);
+        }
+        original._retried = true;
+        try {
+            const token = await refreshAccessToken();
+            original.headers.Authorization = `Bearer ${token}`;
+            return api(original);
+        } catch {
+            clearSession();
+            return Promise.reject(error);
+        }
+    }
+);
+
 export default api;
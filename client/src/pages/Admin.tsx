@@ -3,7 +3,7 @@ import { useEffect, useState } from "react";
 import { BentoGrid } from "../components/BentoGrid";
 import { ThemeToggle } from "../components/ThemeToggle";
 import { LogOut, Eye, Loader2, BarChart2 } from "lucide-react";
-import api from "../lib/api";
+import api, { clearSession } from "../lib/api";
 import { Dashboard } from "./Dashboard";
 
 export const Admin = () => {
@@ -37,7 +37,7 @@ export const Admin = () => {
 
             } catch (error) {
                 setIsAuthenticated(false);
-                localStorage.removeItem('token');
+                clearSession();
                 navigate("/admin-login");
             } finally {
                 setIsLoading(false);
@@ -57,7 +57,12 @@ export const Admin = () => {
     }, []);
 
     const handleLogout = async () => {
-        localStorage.removeItem('token');
+        try {
+            await api.post('/auth/logout');
+        } catch {
+            // The local session is cleared regardless.
+        }
+        clearSession();
         navigate("/");
     };
 
@@ -1,6 +1,6 @@
 import { useState, useEffect, useCallback } from "react";
 import { useNavigate } from "react-router-dom";
-import api from "../lib/api";
+import api, { saveSession, clearSession } from "../lib/api";
 import { Loader2, Sun, Eye, EyeOff } from "lucide-react";
 import { toast } from "sonner";
 import { AnalogClock } from "../components/AnalogClock";
@@ -112,7 +112,7 @@ export const AdminLogin = () => {
                     const me = await api.get('/auth/me');
                     navigate(`/admin/${me.data.username}`);
                 } catch (e) {
-                    localStorage.removeItem('token');
+                    clearSession();
                 }
             }
         };
@@ -124,8 +124,17 @@ export const AdminLogin = () => {
 
         setIsLoading(true);
         try {
-            const response = await api.post('/auth/login', { email, password });
-            localStorage.setItem('token', response.data.token);
+            let response = await api.post('/auth/login', { email, password });
+            if (response.data.two_factor_required) {
+                const code = window.prompt("Enter the 6-digit code from your authenticator app (or a recovery code)");
+                if (!code) return;
+                const isTotp = /^\d{6}$/.test(code.trim());
+                response = await api.post('/auth/login/2fa', {
+                    challenge_token: response.data.challenge_token,
+                    ...(isTotp ? { code: code.trim() } : { recovery_code: code.trim() }),
+                });
+            }
+            saveSession(response.data);
             toast.success("Welcome back!");
             const username = response.data.user?.username;
             navigate(username ? `/admin/${username}` : "/admin");
@@ -10,8 +10,8 @@ import {
 } from "lucide-react";
 
 // ─── Types ────────────────────────────────────────────────────────────────────
-interface WidgetStat { widget_id: string; url: string; custom_title: string; custom_image: string; total: number; unique: number; }
-interface TimelinePoint { date: string; total: number; }
+interface WidgetStat { widget_id: string; page?: string; url: string; custom_title: string; custom_image: string; total: number; daily_unique: number; views: number; ctr: number; }
+interface TimelinePoint { date: string; total: number; views: number; ctr: number; }
 interface ReferrerStat { domain: string; count: number; }
 interface DeviceStat { device_type: string; count: number; }
 interface GeoStat { location: string; country_code: string; count: number; }
@@ -170,7 +170,9 @@ export const Dashboard = () => {
     }, [selectedCountry, locations, selectedRegion]);
 
     const totalClicks = widgets.reduce((s, w) => s + w.total, 0);
-    const uniqueClicks = widgets.reduce((s, w) => s + w.unique, 0);
+    const uniqueClicks = widgets.reduce((s, w) => s + w.daily_unique, 0);
+    const totalViews = timeline.reduce((s, p) => s + p.views, 0);
+    const formatCtr = (ctr: number) => `${(ctr * 100).toFixed(1)}%`;
     const maxTotal = Math.max(...widgets.map(w => w.total), 1);
 
     const TABS: { id: Tab; label: string }[] = [
@@ -243,8 +245,8 @@ export const Dashboard = () => {
             <div className="grid grid-cols-2 sm:grid-cols-4 gap-2 sm:gap-3 mb-4 sm:mb-6">
                 {[
                     { label: "Total Clicks", value: totalClicks.toLocaleString(), icon: <MousePointerClick className="h-4 w-4" /> },
-                    { label: "Unique Visitors", value: uniqueClicks.toLocaleString(), icon: <Users className="h-4 w-4" /> },
-                    { label: "Widgets", value: widgets.length, icon: <TrendingUp className="h-4 w-4" /> },
+                    { label: "Daily Unique Visitors", value: uniqueClicks.toLocaleString(), icon: <Users className="h-4 w-4" /> },
+                    { label: "Page Views", value: totalViews.toLocaleString(), icon: <TrendingUp className="h-4 w-4" /> },
                     { label: "Countries", value: geo.length, icon: <Globe className="h-4 w-4" /> },
                 ].map(card => (
                     <div key={card.label} className="bg-white dark:bg-white/5 border border-gray-100 dark:border-white/10 rounded-2xl p-3 sm:p-4 flex items-center gap-2 sm:gap-3 backdrop-blur-sm">
@@ -316,7 +318,8 @@ export const Dashboard = () => {
                                                 <MousePointerClick className="h-3 w-3 sm:h-3.5 sm:w-3.5" />
                                                 {w.total.toLocaleString()}
                                             </div>
-                                            <p className="text-[10px] sm:text-xs text-black/50 dark:text-white/60">{w.unique.toLocaleString()} unique</p>
+                                            <p className="text-[10px] sm:text-xs text-black/50 dark:text-white/60">{w.daily_unique.toLocaleString()} daily unique</p>
+                                            <p className="text-[10px] sm:text-xs text-black/50 dark:text-white/60">{formatCtr(w.ctr)} CTR · {w.views.toLocaleString()} views</p>
                                         </div>
                                     </div>
                                 );
@@ -367,7 +370,16 @@ export const Dashboard = () => {
                                                 const d = new Date(v + "T00:00:00");
                                                 return d.toLocaleDateString("en-US", { weekday: "long", year: "numeric", month: "short", day: "numeric" });
                                             }}
-                                            formatter={(v: any) => [v, "Clicks"]}
+                                            formatter={(v: any, name: any) => [v, name === "views" ? "Views" : "Clicks"]}
+                                        />
+                                        <Area
+                                            type="monotone"
+                                            dataKey="views"
+                                            stroke={isDark ? "rgba(255,255,255,0.4)" : "rgba(0,0,0,0.3)"}
+                                            strokeWidth={2}
+                                            strokeDasharray="4 4"
+                                            fill="none"
+                                            dot={false}
                                         />
                                         <Area
                                             type="monotone"
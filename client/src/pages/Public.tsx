@@ -6,7 +6,7 @@ import { ThemeToggle } from "../components/ThemeToggle";
 export const Public = () => {
     // Display marketing admin's widgets on homepage
     const [isScrolled, setIsScrolled] = useState(false);
-    const { username } = useParams<{ username: string }>();
+    const { username, slug } = useParams<{ username: string; slug?: string }>();
     const targetUsername = username || "marketing";
 
     useEffect(() => {
@@ -45,7 +45,7 @@ export const Public = () => {
                 <ThemeToggle />
             </div>
 
-            <BentoGrid isEditable={false} publicUsername={targetUsername} />
+            <BentoGrid isEditable={false} publicUsername={targetUsername} pageSlug={slug} />
         </div>
     );
 };
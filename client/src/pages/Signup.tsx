@@ -1,6 +1,6 @@
 import { useState, useEffect, useCallback } from "react";
 import { useNavigate } from "react-router-dom";
-import api from "../lib/api";
+import api, { saveSession, clearSession } from "../lib/api";
 import { Loader2, Sun, Eye, EyeOff } from "lucide-react";
 import { toast } from "sonner";
 import { AnalogClock } from "../components/AnalogClock";
@@ -93,7 +93,7 @@ export const Signup = () => {
                     const me = await api.get('/auth/me');
                     navigate(`/admin/${me.data.username}`);
                 } catch (e) {
-                    localStorage.removeItem('token');
+                    clearSession();
                 }
             }
         };
@@ -106,7 +106,7 @@ export const Signup = () => {
         setIsLoading(true);
         try {
             const response = await api.post('/auth/signup', { email, password, username });
-            localStorage.setItem('token', response.data.token);
+            saveSession(response.data);
             toast.success("Account created!");
             const targetUsername = response.data.user?.username || username;
             navigate(`/admin/${targetUsername}`);
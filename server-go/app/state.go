@@ -3,10 +3,14 @@ package app
 import (
 	"brolink-server/config"
 	"brolink-server/db"
+	"brolink-server/services"
 )
 
 type State struct {
-	Config *config.Config
-	Mongo  *db.Mongo
-	Redis  *db.Redis
+	Config    *config.Config
+	Mongo     *db.Mongo
+	Redis     *db.Redis
+	Mailer    services.Mailer
+	Keys      *services.KeyRing
+	Passwords *services.Passwords
 }
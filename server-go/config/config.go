@@ -1,18 +1,89 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// OAuthProvider configures one social login provider. Kind is "oidc" for
+// OpenID Connect providers discovered from Issuer, or "github".
+type OAuthProvider struct {
+	Name         string
+	Kind         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// DefaultJWTSecret is the development fallback for JWT_SECRET. Validate
+// refuses it outside development.
+const DefaultJWTSecret = "secret_key"
+
+// Supported JWT_ALGORITHM values.
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgEdDSA = "EdDSA"
+)
+
+// Supported PASSWORD_HASH values.
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
 )
 
 type Config struct {
+	AppEnv              string
 	MongoDBURI          string
 	RedisURL            string
 	JWTSecret           string
+	JWTAlgorithm        string
+	JWTKeyRotation      time.Duration
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
+	PasswordHash        string
+	BcryptCost          int
+	Argon2Memory        int
+	Argon2Time          int
+	Argon2Threads       int
+	PasswordMinLength   int
+	PasswordMaxLength   int
+	PasswordBreachList  string
 	CloudinaryCloudName string
 	CloudinaryAPIKey    string
 	CloudinaryAPISecret string
+	SMTPHost            string
+	SMTPPort            string
+	SMTPUsername        string
+	SMTPPassword        string
+	MailFrom            string
+	MailDir             string
+	ClientURL           string
+	ServerURL           string
+	UsernameRedirectTTL time.Duration
+	AccountDeleteGrace  time.Duration
+	OAuthProviders      map[string]OAuthProvider
+	WebAuthnRPID        string
+	WebAuthnOrigins     []string
+	RequireVerifiedMail bool
+	RequireAdmin2FA     bool
+	LoginMaxAttempts    int
+	LoginMaxAttemptsIP  int
+	LoginWindow         time.Duration
+	LoginLockout        time.Duration
+	BentoMaxWidgets     int
+	BentoRevisionLimit  int
+	BentoRevisionWindow time.Duration
+	BentoPreviewTTL     time.Duration
+	BentoPageLimits     map[string]int
 	AllowedOrigins      []string
 	Port                string
 }
@@ -20,18 +91,66 @@ type Config struct {
 func Load() *Config {
 	mongoURI := getenv("MONGODB_URI", "mongodb://localhost:27017/bento")
 	redisURL := getenv("REDIS_URL", "redis://127.0.0.1/")
-	jwtSecret := getenv("JWT_SECRET", "secret_key")
+	appEnv := strings.ToLower(getenv("APP_ENV", "production"))
+	jwtSecret := getenv("JWT_SECRET", DefaultJWTSecret)
+	jwtAlgorithm := getenv("JWT_ALGORITHM", JWTAlgHS256)
+	if strings.EqualFold(jwtAlgorithm, JWTAlgEdDSA) {
+		jwtAlgorithm = JWTAlgEdDSA
+	} else {
+		jwtAlgorithm = strings.ToUpper(jwtAlgorithm)
+	}
+	jwtKeyRotation := getduration("JWT_KEY_ROTATION", 30*24*time.Hour)
+	accessTTL := getduration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTTL := getduration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	passwordHash := strings.ToLower(getenv("PASSWORD_HASH", PasswordHashBcrypt))
+	bcryptCost := getint("BCRYPT_COST", 10)
+	argon2Memory := getint("ARGON2_MEMORY_KIB", 64*1024)
+	argon2Time := getint("ARGON2_ITERATIONS", 3)
+	argon2Threads := getint("ARGON2_THREADS", 2)
+	passwordMin := getint("PASSWORD_MIN_LENGTH", 8)
+	passwordMax := getint("PASSWORD_MAX_LENGTH", 128)
+	breachList := strings.TrimSpace(os.Getenv("PASSWORD_BREACH_LIST"))
 	cloudName := os.Getenv("CLOUDINARY_CLOUD_NAME")
 	cloudKey := os.Getenv("CLOUDINARY_API_KEY")
 	cloudSecret := os.Getenv("CLOUDINARY_API_SECRET")
 
+	smtpHost := strings.TrimSpace(os.Getenv("SMTP_HOST"))
+	smtpPort := getenv("SMTP_PORT", "587")
+	smtpUser := os.Getenv("SMTP_USERNAME")
+	smtpPass := os.Getenv("SMTP_PASSWORD")
+	mailFrom := getenv("MAIL_FROM", "BroLink <no-reply@link.brototype.com>")
+	mailDir := strings.TrimSpace(os.Getenv("MAIL_DIR"))
+	clientURL := strings.TrimRight(getenv("CLIENT_URL", "http://localhost:5173"), "/")
+	requireVerified := getbool("REQUIRE_EMAIL_VERIFICATION", false)
+	usernameRedirectTTL := getduration("USERNAME_REDIRECT_TTL", 30*24*time.Hour)
+	if strings.TrimSpace(os.Getenv("USERNAME_REDIRECT_TTL")) == "0" {
+		// "0" turns old-handle redirects off entirely.
+		usernameRedirectTTL = 0
+	}
+	accountDeleteGrace := getduration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour)
+	requireAdmin2FA := getbool("REQUIRE_ADMIN_2FA", false)
+	loginMax := getint("LOGIN_MAX_ATTEMPTS", 5)
+	loginMaxIP := getint("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+	loginWindow := getduration("LOGIN_ATTEMPT_WINDOW", 15*time.Minute)
+	loginLockout := getduration("LOGIN_LOCKOUT", 15*time.Minute)
+	bentoMaxWidgets := getint("BENTO_MAX_WIDGETS", 100)
+	bentoRevisionLimit := getint("BENTO_REVISION_LIMIT", 50)
+	bentoRevisionWindow := getduration("BENTO_REVISION_WINDOW", 10*time.Minute)
+	bentoPreviewTTL := getduration("BENTO_PREVIEW_TTL", 24*time.Hour)
+	bentoPageLimits := getlimits("BENTO_PAGE_LIMITS", map[string]int{
+		"user":        3,
+		"moderator":   5,
+		"support":     5,
+		"super-admin": 25,
+	})
+
 	allowed := []string{
 		"https://bro-links.vercel.app",
 		"https://link.brototype.com",
 		"http://localhost:5173",
 	}
-	if clientURL := strings.TrimSpace(os.Getenv("CLIENT_URL")); clientURL != "" {
-		allowed = append(allowed, clientURL)
+	if origin := strings.TrimSpace(os.Getenv("CLIENT_URL")); origin != "" {
+		allowed = append(allowed, origin)
 	}
 	allowed = uniqueSorted(allowed)
 
@@ -43,22 +162,167 @@ func Load() *Config {
 		port = "5000"
 	}
 
+	serverURL := strings.TrimRight(getenv("SERVER_URL", "http://localhost:"+port), "/")
+
+	rpID := strings.TrimSpace(os.Getenv("WEBAUTHN_RP_ID"))
+	if rpID == "" {
+		if parsed, err := url.Parse(clientURL); err == nil {
+			rpID = parsed.Hostname()
+		}
+	}
+	rpOrigins := uniqueSorted(append([]string{clientURL}, strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",")...))
+
 	return &Config{
 		MongoDBURI:          mongoURI,
 		RedisURL:            redisURL,
+		AppEnv:              appEnv,
 		JWTSecret:           jwtSecret,
+		JWTAlgorithm:        jwtAlgorithm,
+		JWTKeyRotation:      jwtKeyRotation,
+		AccessTokenTTL:      accessTTL,
+		RefreshTokenTTL:     refreshTTL,
+		PasswordHash:        passwordHash,
+		BcryptCost:          bcryptCost,
+		Argon2Memory:        argon2Memory,
+		Argon2Time:          argon2Time,
+		Argon2Threads:       argon2Threads,
+		PasswordMinLength:   passwordMin,
+		PasswordMaxLength:   passwordMax,
+		PasswordBreachList:  breachList,
 		CloudinaryCloudName: cloudName,
 		CloudinaryAPIKey:    cloudKey,
 		CloudinaryAPISecret: cloudSecret,
+		SMTPHost:            smtpHost,
+		SMTPPort:            smtpPort,
+		SMTPUsername:        smtpUser,
+		SMTPPassword:        smtpPass,
+		MailFrom:            mailFrom,
+		MailDir:             mailDir,
+		ClientURL:           clientURL,
+		ServerURL:           serverURL,
+		UsernameRedirectTTL: usernameRedirectTTL,
+		AccountDeleteGrace:  accountDeleteGrace,
+		OAuthProviders:      loadOAuthProviders(),
+		WebAuthnRPID:        rpID,
+		WebAuthnOrigins:     rpOrigins,
+		RequireVerifiedMail: requireVerified,
+		RequireAdmin2FA:     requireAdmin2FA,
+		LoginMaxAttempts:    loginMax,
+		LoginMaxAttemptsIP:  loginMaxIP,
+		LoginWindow:         loginWindow,
+		LoginLockout:        loginLockout,
+		BentoMaxWidgets:     bentoMaxWidgets,
+		BentoRevisionLimit:  bentoRevisionLimit,
+		BentoRevisionWindow: bentoRevisionWindow,
+		BentoPreviewTTL:     bentoPreviewTTL,
+		BentoPageLimits:     bentoPageLimits,
 		AllowedOrigins:      allowed,
 		Port:                port,
 	}
 }
 
+func loadOAuthProviders() map[string]OAuthProvider {
+	providers := map[string]OAuthProvider{}
+	if id := strings.TrimSpace(os.Getenv("GOOGLE_CLIENT_ID")); id != "" {
+		providers["google"] = OAuthProvider{
+			Name:         "google",
+			Kind:         "oidc",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     id,
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+	}
+	if id := strings.TrimSpace(os.Getenv("GITHUB_CLIENT_ID")); id != "" {
+		providers["github"] = OAuthProvider{
+			Name:         "github",
+			Kind:         "github",
+			ClientID:     id,
+			ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			Scopes:       []string{"read:user", "user:email"},
+		}
+	}
+	issuer := strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/")
+	if id := strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")); id != "" && issuer != "" {
+		name := getenv("OIDC_PROVIDER_NAME", "oidc")
+		providers[name] = OAuthProvider{
+			Name:         name,
+			Kind:         "oidc",
+			Issuer:       issuer,
+			ClientID:     id,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			Scopes:       strings.Fields(getenv("OIDC_SCOPES", "openid email profile")),
+		}
+	}
+	return providers
+}
+
+// DevMode reports whether APP_ENV is "development".
+func (c *Config) DevMode() bool {
+	return c.AppEnv == "development"
+}
+
+// PageLimit returns how many bento pages a user with role may have. Roles
+// without their own entry in BENTO_PAGE_LIMITS get the "user" limit.
+func (c *Config) PageLimit(role string) int {
+	if n, ok := c.BentoPageLimits[role]; ok {
+		return n
+	}
+	return c.BentoPageLimits["user"]
+}
+
+// Validate reports settings the server must not start with.
+func (c *Config) Validate() error {
+	if c.JWTSecret == DefaultJWTSecret && !c.DevMode() {
+		return errors.New("JWT_SECRET is unset or the default; set it, or APP_ENV=development for local use")
+	}
+	switch c.JWTAlgorithm {
+	case JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA:
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q", c.JWTAlgorithm)
+	}
+	switch c.PasswordHash {
+	case PasswordHashBcrypt, PasswordHashArgon2id:
+	default:
+		return fmt.Errorf("unsupported PASSWORD_HASH %q", c.PasswordHash)
+	}
+	if c.BcryptCost < 4 || c.BcryptCost > 31 {
+		return errors.New("BCRYPT_COST must be between 4 and 31")
+	}
+	if c.PasswordHash == PasswordHashArgon2id {
+		switch {
+		case c.Argon2Time < 1:
+			return errors.New("ARGON2_ITERATIONS must be at least 1")
+		case c.Argon2Threads < 1 || c.Argon2Threads > 255:
+			return errors.New("ARGON2_THREADS must be between 1 and 255")
+		case c.Argon2Memory < 8*c.Argon2Threads:
+			return errors.New("ARGON2_MEMORY_KIB must be at least 8 times ARGON2_THREADS")
+		}
+	}
+	// Without SMTP, mail goes to MAIL_DIR or the log, tokens and all.
+	if !c.SMTPConfigured() && !c.DevMode() {
+		return errors.New("SMTP_HOST is unset; set it, or APP_ENV=development to keep mail local")
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		return fmt.Errorf("MAIL_FROM is not a valid address: %v", err)
+	}
+	if c.BentoRevisionLimit < 1 {
+		return errors.New("BENTO_REVISION_LIMIT must be at least 1")
+	}
+	if c.PasswordMinLength > c.PasswordMaxLength {
+		return errors.New("PASSWORD_MIN_LENGTH is greater than PASSWORD_MAX_LENGTH")
+	}
+	return nil
+}
+
 func (c *Config) CloudinaryConfigured() bool {
 	return c.CloudinaryCloudName != "" && c.CloudinaryAPIKey != "" && c.CloudinaryAPISecret != ""
 }
 
+func (c *Config) SMTPConfigured() bool {
+	return c.SMTPHost != ""
+}
+
 func getenv(key, fallback string) string {
 	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
 		return value
@@ -66,6 +330,53 @@ func getenv(key, fallback string) string {
 	return fallback
 }
 
+func getduration(key string, fallback time.Duration) time.Duration {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getint(key string, fallback int) int {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+// getlimits reads "name=n" pairs separated by commas, e.g.
+// "user=3,super-admin=25", over a copy of fallback.
+func getlimits(key string, fallback map[string]int) map[string]int {
+	limits := make(map[string]int, len(fallback))
+	for name, n := range fallback {
+		limits[name] = n
+	}
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n > 0 {
+			limits[strings.TrimSpace(name)] = n
+		}
+	}
+	return limits
+}
+
+func getbool(key string, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return fallback
+}
+
 func uniqueSorted(values []string) []string {
 	seen := map[string]struct{}{}
 	unique := make([]string, 0, len(values))
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRequiresSMTPOutsideDevelopment(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("SMTP_HOST", "")
+
+	t.Setenv("APP_ENV", "production")
+	err := Load().Validate()
+	if err == nil || !strings.Contains(err.Error(), "SMTP_HOST") {
+		t.Fatalf("production without SMTP: got %v", err)
+	}
+
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	if err := Load().Validate(); err != nil {
+		t.Fatalf("production with SMTP: %v", err)
+	}
+
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("APP_ENV", "development")
+	if err := Load().Validate(); err != nil {
+		t.Fatalf("development without SMTP: %v", err)
+	}
+}
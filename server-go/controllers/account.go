@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	passwordResetTTL     = 1 * time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+type forgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type verifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
+// ForgotPassword emails a password reset link. It responds the same way
+// whether or not the address belongs to an account.
+func (ac *AuthController) ForgotPassword(c *fiber.Ctx) error {
+	var payload forgotPasswordPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	email := strings.TrimSpace(payload.Email)
+	if email == "" {
+		return respondError(c, fiber.StatusBadRequest, "email is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := fiber.Map{"message": "If that email is registered, a reset link has been sent"}
+
+	var user models.User
+	err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.IsBlocked) {
+		return c.JSON(resp)
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+
+	token, err := issueActionToken(ctx, ac.State, &user, models.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", ac.State.Config.ClientURL, url.QueryEscape(token))
+	sendMail(ac.State, services.MailMessage{
+		To:      user.Email,
+		Subject: "Reset your BroLink password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your BroLink account.\n"+
+			"Open this link within an hour to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", user.Username, link),
+	})
+
+	return c.JSON(resp)
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out everywhere.
+func (ac *AuthController) ResetPassword(c *fiber.Ctx) error {
+	var payload resetPasswordPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Token) == "" {
+		return respondError(c, fiber.StatusBadRequest, "token is required")
+	}
+	if err := ac.State.Passwords.Check(payload.Password); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := consumeActionToken(ctx, ac.State, payload.Token, models.TokenPasswordReset)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Reset failed")
+	}
+
+	hashed, err := ac.State.Passwords.Hash(payload.Password)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Hash failed")
+	}
+
+	res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": token.User, "email": token.Email},
+		bson.M{"$set": bson.M{
+			"password":  hashed,
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Reset failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+
+	if err := revokeSessions(ctx, ac.State, bson.M{"user": token.User}); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Reset failed")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditPasswordReset, token.User, nil))
+
+	return c.JSON(fiber.Map{"message": "Password updated"})
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified.
+func (ac *AuthController) VerifyEmail(c *fiber.Ctx) error {
+	var payload verifyEmailPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Token) == "" {
+		return respondError(c, fiber.StatusBadRequest, "token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := consumeActionToken(ctx, ac.State, payload.Token, models.TokenEmailVerification)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Verification failed")
+	}
+
+	res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": token.User, "email": token.Email},
+		bson.M{"$set": bson.M{
+			"email_verified": true,
+			"updatedAt":      primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Verification failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+
+	return c.JSON(fiber.Map{"message": "Email verified"})
+}
+
+// ResendVerification sends a fresh verification email to the current user.
+func (ac *AuthController) ResendVerification(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"_id": userCtx.ID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if user.EmailVerified {
+		return respondError(c, fiber.StatusBadRequest, "Email already verified")
+	}
+
+	if err := sendVerificationEmail(ctx, ac.State, &user); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+	return c.JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+func sendVerificationEmail(ctx context.Context, state *app.State, user *models.User) error {
+	token, err := issueActionToken(ctx, state, user, models.TokenEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", state.Config.ClientURL, url.QueryEscape(token))
+	sendMail(state, services.MailMessage{
+		To:      user.Email,
+		Subject: "Verify your BroLink email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm this address for your BroLink account by opening:\n\n%s\n\n"+
+			"The link expires in 48 hours.\n", user.Username, link),
+	})
+	return nil
+}
+
+// issueActionToken replaces any outstanding token of the same purpose for
+// the user and returns the new raw token.
+func issueActionToken(ctx context.Context, state *app.State, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	raw, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	tokens := state.Mongo.ActionTokens()
+	if _, err := tokens.DeleteMany(ctx, bson.M{"user": user.ID, "purpose": purpose}); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	doc := models.ActionToken{
+		ID:        primitive.NewObjectID(),
+		User:      user.ID,
+		Purpose:   purpose,
+		Hash:      hashToken(raw),
+		Email:     user.Email,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+		ExpiresAt: primitive.NewDateTimeFromTime(now.Add(ttl)),
+	}
+	if _, err := tokens.InsertOne(ctx, doc); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeActionToken atomically marks an unused, unexpired token as used.
+// It returns mongo.ErrNoDocuments when no such token exists.
+func consumeActionToken(ctx context.Context, state *app.State, raw, purpose string) (*models.ActionToken, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var token models.ActionToken
+	err := state.Mongo.ActionTokens().FindOneAndUpdate(ctx,
+		bson.M{
+			"hash":       hashToken(strings.TrimSpace(raw)),
+			"purpose":    purpose,
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// sendMail delivers in the background so slow SMTP servers don't hold up
+// the request.
+func sendMail(state *app.State, msg services.MailMessage) {
+	if state.Mailer == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := state.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("mail to %s failed: %v", msg.To, err)
+		}
+	}()
+}
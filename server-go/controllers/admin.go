@@ -5,6 +5,8 @@ import (
 	"brolink-server/middleware"
 	"brolink-server/models"
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,6 +16,10 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// errStaffTarget means an admin action targets staff the actor may not
+// manage.
+var errStaffTarget = errors.New("target is staff")
+
 type AdminController struct {
 	State *app.State
 }
@@ -22,12 +28,16 @@ type blockPayload struct {
 	IsBlocked bool `json:"is_blocked"`
 }
 
-func (ac *AdminController) GetUsers(c *fiber.Ctx) error {
-	userCtx, ok := middleware.CurrentUser(c)
-	if !ok || userCtx.Role != "super-admin" {
-		return respondError(c, fiber.StatusForbidden, "Admin access required")
-	}
+type rolePayload struct {
+	Role string `json:"role"`
+}
+
+type roleView struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
 
+func (ac *AdminController) GetUsers(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -54,8 +64,8 @@ func (ac *AdminController) GetUsers(c *fiber.Ctx) error {
 
 func (ac *AdminController) BlockUser(c *fiber.Ctx) error {
 	userCtx, ok := middleware.CurrentUser(c)
-	if !ok || userCtx.Role != "super-admin" {
-		return respondError(c, fiber.StatusForbidden, "Admin access required")
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
 	}
 
 	id := c.Params("id")
@@ -75,11 +85,29 @@ func (ac *AdminController) BlockUser(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Only staff who can assign roles may block other staff, so a moderator
+	// can't lock out the admins above them.
+	filter := bson.M{"_id": objID}
+	if !models.HasPermission(userCtx.Role, models.PermRolesAssign) {
+		var target models.User
+		err = ac.State.Mongo.Users().FindOne(ctx, filter).Decode(&target)
+		if err == mongo.ErrNoDocuments {
+			return respondError(c, fiber.StatusNotFound, "User not found")
+		}
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+		if target.Role != models.RoleUser {
+			return respondError(c, fiber.StatusForbidden, "Insufficient permissions")
+		}
+		filter["role"] = models.RoleUser
+	}
+
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	var updated models.User
 	err = ac.State.Mongo.Users().FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": objID},
+		filter,
 		bson.M{"$set": bson.M{"is_blocked": payload.IsBlocked}},
 		opts,
 	).Decode(&updated)
@@ -90,5 +118,211 @@ func (ac *AdminController) BlockUser(c *fiber.Ctx) error {
 		return respondError(c, fiber.StatusInternalServerError, "Update failed")
 	}
 
+	if updated.IsBlocked {
+		if err := revokeSessions(ctx, ac.State, bson.M{"user": updated.ID}); err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+	}
+
+	action := models.AuditUnblock
+	if updated.IsBlocked {
+		action = models.AuditBlock
+	}
+	recordAudit(ac.State, c, adminAudit(action, userCtx, updated.ID, nil))
+
 	return c.JSON(updated.Admin())
 }
+
+func (ac *AdminController) GetUserSessions(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	views, err := listSessions(ctx, ac.State, objID, userCtx.SessionID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(views)
+}
+
+func (ac *AdminController) RevokeUserSessions(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch _, err := ac.managedUser(ctx, userCtx, objID); {
+	case err == mongo.ErrNoDocuments:
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	case err == errStaffTarget:
+		return respondError(c, fiber.StatusForbidden, "Insufficient permissions")
+	case err != nil:
+		return respondError(c, fiber.StatusInternalServerError, "Revoke failed")
+	}
+
+	if err := revokeSessions(ctx, ac.State, bson.M{"user": objID}); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Revoke failed")
+	}
+	recordAudit(ac.State, c, adminAudit(models.AuditSessionsRevoke, userCtx, objID, nil))
+	return c.JSON(fiber.Map{"message": "Sessions revoked"})
+}
+
+// GetLockouts lists recent login lockouts, newest first. ?email= narrows
+// the list to one account.
+func (ac *AdminController) GetLockouts(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if email := strings.ToLower(strings.TrimSpace(c.Query("email"))); email != "" {
+		filter["email"] = email
+	}
+	limit := int64(c.QueryInt("limit", 100))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(limit)
+	cursor, err := ac.State.Mongo.LockoutEvents().Find(ctx, filter, opts)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	defer cursor.Close(ctx)
+
+	events := make([]models.LockoutEvent, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(events)
+}
+
+// UnlockUser lifts a login lockout on a user's account.
+func (ac *AdminController) UnlockUser(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.managedUser(ctx, userCtx, objID)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	case err == errStaffTarget:
+		return respondError(c, fiber.StatusForbidden, "Insufficient permissions")
+	case err != nil:
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	if err := clearAccountLockout(ctx, ac.State, user.Email); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Unlock failed")
+	}
+	recordAudit(ac.State, c, adminAudit(models.AuditUnlock, userCtx, objID, nil))
+	return c.JSON(fiber.Map{"message": "Account unlocked"})
+}
+
+// managedUser loads the user an admin action targets. As with BlockUser,
+// only staff who can assign roles may act on other staff, so a moderator
+// can't act against the admins above them.
+func (ac *AdminController) managedUser(ctx context.Context, actor *middleware.AuthUser, id primitive.ObjectID) (*models.User, error) {
+	var target models.User
+	if err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"_id": id}).Decode(&target); err != nil {
+		return nil, err
+	}
+	if target.Role != models.RoleUser && !models.HasPermission(actor.Role, models.PermRolesAssign) {
+		return nil, errStaffTarget
+	}
+	return &target, nil
+}
+
+// GetRoles lists the assignable roles and what each one may do.
+func (ac *AdminController) GetRoles(c *fiber.Ctx) error {
+	roles := make([]roleView, 0, len(models.Roles))
+	for _, role := range models.Roles {
+		roles = append(roles, roleView{Role: role, Permissions: models.RolePermissions[role]})
+	}
+	return c.JSON(roles)
+}
+
+// SetUserRole assigns a role. The user's sessions are revoked so the change,
+// and especially a demotion, applies immediately rather than at the next
+// token refresh.
+func (ac *AdminController) SetUserRole(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+	if objID == userCtx.ID {
+		return respondError(c, fiber.StatusBadRequest, "You cannot change your own role")
+	}
+
+	var payload rolePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	role := strings.TrimSpace(payload.Role)
+	if !models.ValidRole(role) {
+		return respondError(c, fiber.StatusBadRequest, "Unknown role")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	var previous models.User
+	err = ac.State.Mongo.Users().FindOneAndUpdate(ctx,
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{
+			"role":      role,
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		}},
+		opts,
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+
+	if previous.Role != role {
+		if err := revokeSessions(ctx, ac.State, bson.M{"user": objID}); err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+	}
+
+	recordAudit(ac.State, c, adminAudit(models.AuditRoleChange, userCtx, objID, map[string]string{
+		"from": previous.Role,
+		"to":   role,
+	}))
+
+	previous.Role = role
+	return c.JSON(previous.Admin())
+}
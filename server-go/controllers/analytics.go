@@ -6,9 +6,9 @@ import (
 	"brolink-server/models"
 	"brolink-server/services"
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -21,12 +21,12 @@ type AnalyticsController struct {
 	State *app.State
 }
 
+// clickPayload identifies a clicked widget. Its URL, title and image are
+// read from the owner's page, never from the caller.
 type clickPayload struct {
 	WidgetID      string `json:"widget_id"`
 	OwnerUsername string `json:"owner_username"`
-	URL           string `json:"url"`
-	CustomTitle   string `json:"custom_title"`
-	CustomImage   string `json:"custom_image"`
+	Page          string `json:"page"`
 	Referrer      string `json:"referrer"`
 }
 
@@ -67,6 +67,8 @@ func referrerDomain(ref string) string {
 	return host
 }
 
+// RecordClick stores a click on one of a user's link widgets. Widgets that
+// are not on the owner's live page are rejected.
 func (ac *AnalyticsController) RecordClick(c *fiber.Ctx) error {
 	var payload clickPayload
 	if err := c.BodyParser(&payload); err != nil {
@@ -77,32 +79,55 @@ func (ac *AnalyticsController) RecordClick(c *fiber.Ctx) error {
 	if payload.WidgetID == "" || payload.OwnerUsername == "" {
 		return respondError(c, fiber.StatusBadRequest, "widget_id and owner_username are required")
 	}
+	payload.Page = strings.TrimSpace(payload.Page)
+	if payload.Page == models.DefaultPageSlug {
+		payload.Page = ""
+	}
+	if payload.Page != "" && !validPageSlug.MatchString(payload.Page) {
+		return respondError(c, fiber.StatusBadRequest, "Invalid page")
+	}
 
-	// Capture request metadata
-	ip := c.IP()
-	ipHash := fmt.Sprintf("%x", sha256.Sum256([]byte(ip)))
-	ua := c.Get("User-Agent")
-	device := classifyDevice(ua)
-	refDomain := referrerDomain(payload.Referrer)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, widget, err := ac.findLinkWidget(ctx, payload.OwnerUsername, payload.Page, payload.WidgetID)
+	if err == errLinkNotFound {
+		return respondError(c, fiber.StatusNotFound, "Widget not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Failed to record click")
+	}
 
 	event := models.ClickEvent{
-		WidgetID:       payload.WidgetID,
-		OwnerUsername:  payload.OwnerUsername,
-		URL:            payload.URL,
-		CustomTitle:    payload.CustomTitle,
-		CustomImage:    payload.CustomImage,
-		IPHash:         ipHash,
-		ReferrerDomain: refDomain,
-		DeviceType:     device,
-		ClickedAt:      time.Now(),
+		WidgetID:       widget.ID,
+		OwnerUsername:  user.Username,
+		Page:           payload.Page,
+		URL:            widget.URL,
+		CustomTitle:    widget.CustomTitle,
+		CustomImage:    widget.CustomImage,
+		ReferrerDomain: referrerDomain(payload.Referrer),
+	}
+	if err := ac.saveClick(c, &event); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Failed to record click")
 	}
 
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Click recorded"})
+}
+
+// saveClick stamps event with the visitor's hashed IP, device and time,
+// stores it, and fills in its location in the background.
+func (ac *AnalyticsController) saveClick(c *fiber.Ctx, event *models.ClickEvent) error {
+	ip := c.IP()
+	event.IPHash = hashIP(ip)
+	event.DeviceType = classifyDevice(c.Get("User-Agent"))
+	event.ClickedAt = time.Now()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	res, err := ac.State.Mongo.Clicks().InsertOne(ctx, event)
 	if err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Failed to record click")
+		return err
 	}
 
 	// Async geo lookup — update the document after insertion
@@ -123,8 +148,7 @@ func (ac *AnalyticsController) RecordClick(c *fiber.Ctx) error {
 			},
 		})
 	}()
-
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Click recorded"})
+	return nil
 }
 
 // ownerUsername resolves the logged-in user's username.
@@ -167,6 +191,14 @@ func (ac *AnalyticsController) buildFilters(c *fiber.Ctx, username string) bson.
 		}
 	}
 
+	// Page Filtering: clicks on the default page are stored without one.
+	page := c.Query("page")
+	if page == models.DefaultPageSlug {
+		match["page"] = bson.M{"$exists": false}
+	} else if page != "" && page != "null" {
+		match["page"] = page
+	}
+
 	// Location Filtering
 	country := c.Query("country")
 	if country != "" && country != "null" {
@@ -180,7 +212,10 @@ func (ac *AnalyticsController) buildFilters(c *fiber.Ctx, username string) bson.
 	return match
 }
 
-// GetAnalytics returns per-widget total and unique click counts.
+// GetAnalytics returns per-widget total and daily unique click counts, with
+// the views of each widget's page and the resulting click-through rate.
+// Closed days come from the daily rollups, so raw events are also counted
+// per day to keep both halves summable.
 func (ac *AnalyticsController) GetAnalytics(c *fiber.Ctx) error {
 	username, err := ac.ownerUsername(c)
 	if err != nil {
@@ -191,16 +226,21 @@ func (ac *AnalyticsController) GetAnalytics(c *fiber.Ctx) error {
 	defer cancel()
 
 	match := ac.buildFilters(c, username)
+	window := rollupWindowFor(ctx, ac.State, match)
 
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: match}},
+		{{Key: "$match", Value: window.rawFilter(match, "clicked_at")}},
 		{{Key: "$group", Value: bson.M{
 			"_id":          "$widget_id",
+			"page":         bson.M{"$first": "$page"},
 			"url":          bson.M{"$first": "$url"},
 			"custom_title": bson.M{"$first": "$custom_title"},
 			"custom_image": bson.M{"$first": "$custom_image"},
 			"total":        bson.M{"$sum": 1},
-			"unique_ips":   bson.M{"$addToSet": "$ip_hash"},
+			"unique_ips": bson.M{"$addToSet": bson.M{
+				"ip":  "$ip_hash",
+				"day": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$clicked_at"}},
+			}},
 		}}},
 		{{Key: "$addFields", Value: bson.M{"unique": bson.M{"$size": "$unique_ips"}}}},
 		{{Key: "$project", Value: bson.M{"unique_ips": 0}}},
@@ -217,12 +257,61 @@ func (ac *AnalyticsController) GetAnalytics(c *fiber.Ctx) error {
 	if err := cursor.All(ctx, &stats); err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Failed to decode analytics")
 	}
+
+	views, err := countViews(ctx, ac.State, window.rawFilter(viewFilter(match), "viewed_at"), bson.M{"$ifNull": bson.A{"$page", ""}})
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch analytics")
+	}
+
+	if window.ok {
+		rolled, err := rollupWidgetStats(ctx, ac.State, window.rollupFilter(match))
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Failed to fetch analytics")
+		}
+		stats = mergeWidgetStats(stats, rolled)
+
+		rolledViews, err := rollupTotals(ctx, ac.State, window.rollupFilter(match), "views", "$page")
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Failed to fetch analytics")
+		}
+		for page, count := range rolledViews {
+			views[page] += count
+		}
+	}
+
+	for i := range stats {
+		stats[i].Views = views[stats[i].Page]
+		stats[i].CTR = clickThroughRate(stats[i].Total, stats[i].Views)
+	}
 	return c.JSON(stats)
 }
 
-// GetTimeline returns click counts per time bucket.
+// mergeWidgetStats adds rolled-up counts to the raw ones. A widget's URL,
+// title and image come from its raw clicks when it has any, as those are
+// newer.
+func mergeWidgetStats(raw, rolled []models.WidgetClickStat) []models.WidgetClickStat {
+	byID := make(map[string]int, len(raw))
+	for i := range raw {
+		byID[raw[i].WidgetID] = i
+	}
+	for _, r := range rolled {
+		i, ok := byID[r.WidgetID]
+		if !ok {
+			raw = append(raw, r)
+			continue
+		}
+		raw[i].Total += r.Total
+		raw[i].DailyUnique += r.DailyUnique
+	}
+	sort.SliceStable(raw, func(i, j int) bool { return raw[i].Total > raw[j].Total })
+	return raw
+}
+
+// GetTimeline returns click counts, page views and click-through rate per
+// time bucket.
 // ?mode=hourly → last 24 h, grouped by "HH:00"
 // ?days=7|30   → last N days, grouped by "YYYY-MM-DD" (default 7)
+// Daily buckets for closed days come from the rollups.
 func (ac *AnalyticsController) GetTimeline(c *fiber.Ctx) error {
 	username, err := ac.ownerUsername(c)
 	if err != nil {
@@ -260,8 +349,14 @@ func (ac *AnalyticsController) GetTimeline(c *fiber.Ctx) error {
 		dateFormat = "%Y-%m-%d"
 	}
 
+	// Rollups are daily, so hourly buckets always read raw events.
+	var window rollupWindow
+	if dateFormat == "%Y-%m-%d" {
+		window = rollupWindowFor(ctx, ac.State, match)
+	}
+
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: match}},
+		{{Key: "$match", Value: window.rawFilter(match, "clicked_at")}},
 		{{Key: "$group", Value: bson.M{
 			"_id": bson.M{
 				"$dateToString": bson.M{
@@ -280,11 +375,54 @@ func (ac *AnalyticsController) GetTimeline(c *fiber.Ctx) error {
 	}
 	defer cursor.Close(ctx)
 
-	points := make([]models.TimelinePoint, 0)
+	var points []models.TimelinePoint
 	if err := cursor.All(ctx, &points); err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Failed to decode timeline")
 	}
-	return c.JSON(points)
+	clicks := make(map[string]int64, len(points))
+	for _, p := range points {
+		clicks[p.Date] = p.Total
+	}
+
+	views, err := countViews(ctx, ac.State, window.rawFilter(viewFilter(match), "viewed_at"), bson.M{
+		"$dateToString": bson.M{"format": dateFormat, "date": "$viewed_at"},
+	})
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch timeline")
+	}
+
+	if window.ok {
+		for counter, totals := range map[string]map[string]int64{"clicks": clicks, "views": views} {
+			rolled, err := rollupTotals(ctx, ac.State, window.rollupFilter(match), counter, "$day")
+			if err != nil {
+				return respondError(c, fiber.StatusInternalServerError, "Failed to fetch timeline")
+			}
+			for day, count := range rolled {
+				totals[day] += count
+			}
+		}
+	}
+	return c.JSON(mergeTimeline(clicks, views))
+}
+
+// mergeTimeline lines up click and view counts by bucket, including buckets
+// with only one of the two, in date order.
+func mergeTimeline(clicks, views map[string]int64) []models.TimelinePoint {
+	points := make([]models.TimelinePoint, 0, len(clicks))
+	for date, total := range clicks {
+		points = append(points, models.TimelinePoint{Date: date, Total: total})
+	}
+	for date, count := range views {
+		if _, ok := clicks[date]; !ok {
+			points = append(points, models.TimelinePoint{Date: date, Views: count})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Date < points[j].Date })
+	for i := range points {
+		points[i].Views = views[points[i].Date]
+		points[i].CTR = clickThroughRate(points[i].Total, points[i].Views)
+	}
+	return points
 }
 
 // GetReferrers returns click counts grouped by referrer domain.
@@ -297,27 +435,14 @@ func (ac *AnalyticsController) GetReferrers(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	match := ac.buildFilters(c, username)
-
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: match}},
-		{{Key: "$group", Value: bson.M{
-			"_id":   "$referrer_domain",
-			"count": bson.M{"$sum": 1},
-		}}},
-		{{Key: "$sort", Value: bson.M{"count": -1}}},
-		{{Key: "$limit", Value: 20}},
-	}
-
-	cursor, err := ac.State.Mongo.Clicks().Aggregate(ctx, pipeline)
+	counts, err := ac.clickBreakdown(ctx, ac.buildFilters(c, username), "$referrer_domain", "referrers")
 	if err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch referrers")
 	}
-	defer cursor.Close(ctx)
 
 	stats := make([]models.ReferrerStat, 0)
-	if err := cursor.All(ctx, &stats); err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Failed to decode referrers")
+	for _, domain := range topCounts(counts, 20) {
+		stats = append(stats, models.ReferrerStat{Domain: domain, Count: counts[domain]})
 	}
 	return c.JSON(stats)
 }
@@ -332,31 +457,55 @@ func (ac *AnalyticsController) GetDevices(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	match := ac.buildFilters(c, username)
+	counts, err := ac.clickBreakdown(ctx, ac.buildFilters(c, username), "$device_type", "devices")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch devices")
+	}
 
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: match}},
+	stats := make([]models.DeviceStat, 0)
+	for _, device := range topCounts(counts, 0) {
+		stats = append(stats, models.DeviceStat{DeviceType: device, Count: counts[device]})
+	}
+	return c.JSON(stats)
+}
+
+// clickBreakdown counts the clicks matching match by the raw event field
+// groupBy, reading closed days from the rollup breakdown of the same name.
+func (ac *AnalyticsController) clickBreakdown(ctx context.Context, match bson.M, groupBy, breakdown string) (map[string]int64, error) {
+	window := rollupWindowFor(ctx, ac.State, match)
+
+	var rows []struct {
+		Key   string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	err := aggregateAll(ctx, ac.State.Mongo.Clicks(), mongo.Pipeline{
+		{{Key: "$match", Value: window.rawFilter(match, "clicked_at")}},
 		{{Key: "$group", Value: bson.M{
-			"_id":   "$device_type",
+			"_id":   groupBy,
 			"count": bson.M{"$sum": 1},
 		}}},
-		{{Key: "$sort", Value: bson.M{"count": -1}}},
-	}
-
-	cursor, err := ac.State.Mongo.Clicks().Aggregate(ctx, pipeline)
+	}, &rows)
 	if err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch devices")
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Key] += row.Count
 	}
-	defer cursor.Close(ctx)
 
-	stats := make([]models.DeviceStat, 0)
-	if err := cursor.All(ctx, &stats); err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Failed to decode devices")
+	if window.ok {
+		rolled, err := rollupBreakdown(ctx, ac.State, window.rollupFilter(match), breakdown)
+		if err != nil {
+			return nil, err
+		}
+		for key, count := range rolled {
+			counts[key] += count
+		}
 	}
-	return c.JSON(stats)
+	return counts, nil
 }
 
-// GetGeo returns click counts grouped by country.
+// GetGeo returns click counts grouped by location, top 30 first.
 func (ac *AnalyticsController) GetGeo(c *fiber.Ctx) error {
 	username, err := ac.ownerUsername(c)
 	if err != nil {
@@ -367,12 +516,14 @@ func (ac *AnalyticsController) GetGeo(c *fiber.Ctx) error {
 	defer cancel()
 
 	match := ac.buildFilters(c, username)
-	if _, ok := match["country"]; !ok {
-		match["country"] = bson.M{"$type": "string", "$ne": ""}
+	window := rollupWindowFor(ctx, ac.State, match)
+	raw := window.rawFilter(match, "clicked_at")
+	if _, ok := raw["country"]; !ok {
+		raw["country"] = bson.M{"$type": "string", "$ne": ""}
 	}
 
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: match}},
+		{{Key: "$match", Value: raw}},
 		{{Key: "$group", Value: bson.M{
 			"_id": bson.M{
 				"city":    "$city",
@@ -382,19 +533,23 @@ func (ac *AnalyticsController) GetGeo(c *fiber.Ctx) error {
 			"country_code": bson.M{"$first": "$country_code"},
 			"count":        bson.M{"$sum": 1},
 		}}},
-		{{Key: "$sort", Value: bson.M{"count": -1}}},
-		{{Key: "$limit", Value: 30}},
 	}
 
-	cursor, err := ac.State.Mongo.Clicks().Aggregate(ctx, pipeline)
-	if err != nil {
+	stats := make([]models.GeoStat, 0)
+	if err := aggregateAll(ctx, ac.State.Mongo.Clicks(), pipeline, &stats); err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Failed to fetch geo")
 	}
-	defer cursor.Close(ctx)
 
-	stats := make([]models.GeoStat, 0)
-	if err := cursor.All(ctx, &stats); err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Failed to decode geo")
+	if window.ok {
+		rolled, err := rollupGeo(ctx, ac.State, window.rollupFilter(match))
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Failed to fetch geo")
+		}
+		stats = mergeGeoStats(stats, rolled)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Count > stats[j].Count })
+	if len(stats) > 30 {
+		stats = stats[:30]
 	}
 
 	for i := range stats {
@@ -414,6 +569,28 @@ func (ac *AnalyticsController) GetGeo(c *fiber.Ctx) error {
 	return c.JSON(stats)
 }
 
+// mergeGeoStats adds rolled-up location counts to the raw ones.
+func mergeGeoStats(raw, rolled []models.GeoStat) []models.GeoStat {
+	byPlace := make(map[[3]string]int, len(raw))
+	for i := range raw {
+		byPlace[[3]string{raw[i].ID.Country, raw[i].ID.Region, raw[i].ID.City}] = i
+	}
+	for _, r := range rolled {
+		place := [3]string{r.ID.Country, r.ID.Region, r.ID.City}
+		i, ok := byPlace[place]
+		if !ok {
+			byPlace[place] = len(raw)
+			raw = append(raw, r)
+			continue
+		}
+		raw[i].Count += r.Count
+		if raw[i].CountryCode == "" {
+			raw[i].CountryCode = r.CountryCode
+		}
+	}
+	return raw
+}
+
 type ClickLogItem struct {
 	ID             string    `json:"id"`
 	URL            string    `json:"url"`
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxAPIKeysPerUser = 25
+	maxAPIKeyNameLen  = 64
+)
+
+type apiKeyPayload struct {
+	Name      *string    `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiKeyCreatedResponse struct {
+	models.APIKey
+	Token string `json:"token"`
+}
+
+// ListTokens returns the current user's API keys without their secrets.
+func (ac *AuthController) ListTokens(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := ac.State.Mongo.APIKeys().Find(ctx, bson.M{"user": userCtx.ID}, opts)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	defer cursor.Close(ctx)
+
+	keys := make([]models.APIKey, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(keys)
+}
+
+// CreateToken issues a new API key. The token is only returned here.
+func (ac *AuthController) CreateToken(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload apiKeyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	name := strings.TrimSpace(getString(payload.Name))
+	if name == "" {
+		return respondError(c, fiber.StatusBadRequest, "name is required")
+	}
+	if len(name) > maxAPIKeyNameLen {
+		return respondError(c, fiber.StatusBadRequest, "name is too long")
+	}
+	scopes, msg := normalizeScopes(payload.Scopes)
+	if msg != "" {
+		return respondError(c, fiber.StatusBadRequest, msg)
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return respondError(c, fiber.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys := ac.State.Mongo.APIKeys()
+	count, err := keys.CountDocuments(ctx, bson.M{"user": userCtx.ID})
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Create failed")
+	}
+	if count >= maxAPIKeysPerUser {
+		return respondError(c, fiber.StatusBadRequest, "API key limit reached")
+	}
+
+	prefix, token, err := newAPIKey()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Create failed")
+	}
+
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		User:      userCtx.ID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      middleware.HashAPIKey(token),
+		Scopes:    scopes,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if payload.ExpiresAt != nil {
+		exp := primitive.NewDateTimeFromTime(*payload.ExpiresAt)
+		key.ExpiresAt = &exp
+	}
+	if _, err := keys.InsertOne(ctx, key); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Create failed")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditAPIKeyCreate, userCtx.ID, map[string]string{"key": key.Prefix}))
+
+	return c.Status(fiber.StatusCreated).JSON(apiKeyCreatedResponse{APIKey: key, Token: token})
+}
+
+// UpdateToken renames a key or changes its scopes.
+func (ac *AuthController) UpdateToken(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	var payload apiKeyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	set := bson.M{}
+	if payload.Name != nil {
+		name := strings.TrimSpace(*payload.Name)
+		if name == "" || len(name) > maxAPIKeyNameLen {
+			return respondError(c, fiber.StatusBadRequest, "Invalid name")
+		}
+		set["name"] = name
+	}
+	if payload.Scopes != nil {
+		scopes, msg := normalizeScopes(payload.Scopes)
+		if msg != "" {
+			return respondError(c, fiber.StatusBadRequest, msg)
+		}
+		set["scopes"] = scopes
+	}
+	if len(set) == 0 {
+		return respondError(c, fiber.StatusBadRequest, "Nothing to update")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.APIKey
+	err = ac.State.Mongo.APIKeys().FindOneAndUpdate(ctx,
+		bson.M{"_id": keyID, "user": userCtx.ID},
+		bson.M{"$set": set},
+		opts,
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "API key not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+	return c.JSON(updated)
+}
+
+// DeleteToken revokes an API key immediately.
+func (ac *AuthController) DeleteToken(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := ac.State.Mongo.APIKeys().DeleteOne(ctx, bson.M{"_id": keyID, "user": userCtx.ID})
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	if res.DeletedCount == 0 {
+		return respondError(c, fiber.StatusNotFound, "API key not found")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditAPIKeyDelete, userCtx.ID, map[string]string{"key": keyID.Hex()}))
+	return c.JSON(fiber.Map{"message": "API key deleted"})
+}
+
+// normalizeScopes de-duplicates the requested scopes and rejects unknown
+// ones. It returns a message when the list is invalid.
+func normalizeScopes(requested []string) ([]string, string) {
+	if len(requested) == 0 {
+		return nil, "at least one scope is required"
+	}
+	seen := map[string]struct{}{}
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, allowed := range models.APIKeyScopes {
+			if scope == allowed {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, "unknown scope " + scope
+		}
+		if _, dup := seen[scope]; dup {
+			continue
+		}
+		seen[scope] = struct{}{}
+		scopes = append(scopes, scope)
+	}
+	return scopes, ""
+}
+
+// newAPIKey returns the visible prefix and the full token, which looks like
+// blk_<8 chars>_<secret>.
+func newAPIKey() (string, string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	prefix := middleware.APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	secret, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return prefix, prefix + "_" + secret, nil
+}
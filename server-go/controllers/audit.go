@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// recordAudit appends event to the audit log, stamped with the caller's IP
+// hash and user agent. A failed write is logged but never fails the request.
+func recordAudit(state *app.State, c *fiber.Ctx, event models.AuditEvent) {
+	event.ID = primitive.NewObjectID()
+	event.IPHash = hashToken(c.IP())
+	event.UserAgent = c.Get("User-Agent")
+	event.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	if event.Result == "" {
+		event.Result = models.AuditSuccess
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := state.Mongo.AuditEvents().InsertOne(ctx, event); err != nil {
+		log.Printf("audit %s: %v", event.Action, err)
+	}
+}
+
+// selfAudit is an event a user performed on their own account.
+func selfAudit(action string, userID primitive.ObjectID, details map[string]string) models.AuditEvent {
+	return models.AuditEvent{Action: action, Actor: &userID, Target: &userID, Details: details}
+}
+
+// adminAudit is an event a staff member performed on another account.
+func adminAudit(action string, actor *middleware.AuthUser, target primitive.ObjectID, details map[string]string) models.AuditEvent {
+	event := models.AuditEvent{Action: action, Target: &target, Details: details}
+	if actor != nil {
+		event.Actor = &actor.ID
+	}
+	return event
+}
+
+// loginAudit records a login attempt. userID is nil when the email matched
+// no account.
+func loginAudit(userID *primitive.ObjectID, email, method, result, reason string) models.AuditEvent {
+	details := map[string]string{"method": method}
+	if reason != "" {
+		details["reason"] = reason
+	}
+	event := models.AuditEvent{Action: models.AuditLogin, Result: result, Actor: userID, Target: userID, Details: details}
+	if userID == nil {
+		event.Email = email
+	}
+	return event
+}
+
+// GetActivity lists recent audit events on the current user's account,
+// newest first. ?before= takes an event id to page back from.
+func (ac *AuthController) GetActivity(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	filter := bson.M{"$or": []bson.M{{"actor": userCtx.ID}, {"target": userCtx.ID}}}
+	if msg := applyAuditPaging(c, filter); msg != "" {
+		return respondError(c, fiber.StatusBadRequest, msg)
+	}
+	return listAudit(c, ac.State, filter)
+}
+
+// GetAudit lists audit events for staff. It filters on ?actor=, ?target=,
+// ?action=, ?result=, ?since= and ?until= (RFC 3339), pages with ?before=
+// and caps results with ?limit=.
+func (ac *AdminController) GetAudit(c *fiber.Ctx) error {
+	filter := bson.M{}
+	for _, field := range []string{"actor", "target"} {
+		if raw := strings.TrimSpace(c.Query(field)); raw != "" {
+			id, err := primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return respondError(c, fiber.StatusBadRequest, "Invalid "+field)
+			}
+			filter[field] = id
+		}
+	}
+	for _, field := range []string{"action", "result"} {
+		if value := strings.TrimSpace(c.Query(field)); value != "" {
+			filter[field] = value
+		}
+	}
+
+	created := bson.M{}
+	if raw := strings.TrimSpace(c.Query("since")); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "Invalid since")
+		}
+		created["$gte"] = primitive.NewDateTimeFromTime(since)
+	}
+	if raw := strings.TrimSpace(c.Query("until")); raw != "" {
+		until, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "Invalid until")
+		}
+		created["$lt"] = primitive.NewDateTimeFromTime(until)
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+
+	if msg := applyAuditPaging(c, filter); msg != "" {
+		return respondError(c, fiber.StatusBadRequest, msg)
+	}
+	return listAudit(c, ac.State, filter)
+}
+
+func applyAuditPaging(c *fiber.Ctx, filter bson.M) string {
+	if raw := strings.TrimSpace(c.Query("before")); raw != "" {
+		before, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return "Invalid before"
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+	return ""
+}
+
+func listAudit(c *fiber.Ctx, state *app.State, filter bson.M) error {
+	limit := int64(c.QueryInt("limit", defaultAuditLimit))
+	if limit <= 0 || limit > maxAuditLimit {
+		limit = defaultAuditLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := state.Mongo.AuditEvents().Find(ctx, filter, opts)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	defer cursor.Close(ctx)
+
+	events := make([]models.AuditEvent, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(events)
+}
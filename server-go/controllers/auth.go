@@ -4,7 +4,9 @@ import (
 	"brolink-server/app"
 	"brolink-server/middleware"
 	"brolink-server/models"
+	"brolink-server/services"
 	"context"
+	"log"
 	"strings"
 	"time"
 
@@ -13,7 +15,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthController struct {
@@ -34,8 +35,10 @@ type authUserResponse struct {
 }
 
 type authResponse struct {
-	Token string           `json:"token"`
-	User  authUserResponse `json:"user"`
+	Token        string           `json:"token"`
+	RefreshToken string           `json:"refresh_token"`
+	ExpiresIn    int64            `json:"expires_in"`
+	User         authUserResponse `json:"user"`
 }
 
 func (ac *AuthController) Signup(c *fiber.Ctx) error {
@@ -51,11 +54,14 @@ func (ac *AuthController) Signup(c *fiber.Ctx) error {
 	if username == "" {
 		return respondError(c, fiber.StatusBadRequest, "username is required")
 	}
+	if usernameReserved(username) {
+		return respondError(c, fiber.StatusBadRequest, "User already exists")
+	}
 	if email == "" {
 		return respondError(c, fiber.StatusBadRequest, "email is required")
 	}
-	if strings.TrimSpace(password) == "" {
-		return respondError(c, fiber.StatusBadRequest, "password is required")
+	if err := ac.State.Passwords.Check(password); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -71,56 +77,28 @@ func (ac *AuthController) Signup(c *fiber.Ctx) error {
 		return respondError(c, fiber.StatusInternalServerError, "Signup failed")
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := ac.State.Passwords.Hash(password)
 	if err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Hash failed")
 	}
 
-	now := primitive.NewDateTimeFromTime(time.Now())
-	fullName := username
-	avatar := ""
 	user := models.User{
-		ID:        primitive.NewObjectID(),
-		Username:  username,
-		Email:     email,
-		Password:  string(hashed),
-		FullName:  &fullName,
-		AvatarURL: &avatar,
-		Role:      "user",
-		IsBlocked: false,
-		CreatedAt: &now,
-		UpdatedAt: &now,
+		Username:      username,
+		Email:         email,
+		Password:      hashed,
+		EmailVerified: false,
 	}
-
-	if _, err := users.InsertOne(ctx, user); err != nil {
+	if err := createAccount(ctx, ac.State, &user); err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Signup failed")
 	}
 
-	configs := ac.State.Mongo.BentoConfigs()
-	configDoc := models.BentoConfig{
-		User:      user.ID,
-		Username:  username,
-		Widgets:   []models.Widget{},
-		Layouts:   map[string]interface{}{},
-		CreatedAt: &now,
-		UpdatedAt: &now,
-	}
-	_, _ = configs.InsertOne(ctx, configDoc)
+	_ = sendVerificationEmail(ctx, ac.State, &user)
 
-	token, err := signToken(ac.State.Config.JWTSecret, user.ID, user.Role)
+	resp, err := ac.issueTokens(ctx, c, &user, false)
 	if err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Signup failed")
 	}
-
-	resp := authResponse{
-		Token: token,
-		User: authUserResponse{
-			ID:       user.ID.Hex(),
-			Username: user.Username,
-			Email:    user.Email,
-			Role:     user.Role,
-		},
-	}
+	recordAudit(ac.State, c, selfAudit(models.AuditSignup, user.ID, map[string]string{"method": "password"}))
 	return c.Status(fiber.StatusCreated).JSON(resp)
 }
 
@@ -139,12 +117,22 @@ func (ac *AuthController) Login(c *fiber.Ctx) error {
 		return respondError(c, fiber.StatusBadRequest, "password is required")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	guard := newLoginGuard(ac.State, c, email)
+	if wait := guard.retryAfter(ctx); wait > 0 {
+		recordAudit(ac.State, c, loginAudit(nil, email, "password", models.AuditFailure, "locked"))
+		return tooManyAttempts(c, wait)
+	}
+
 	var user models.User
 	err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err == mongo.ErrNoDocuments {
+		recordAudit(ac.State, c, loginAudit(nil, email, "password", models.AuditFailure, "unknown_email"))
+		if locked := guard.recordFailure(ctx, nil); locked > 0 {
+			return tooManyAttempts(c, locked)
+		}
 		return respondError(c, fiber.StatusBadRequest, "Invalid credentials")
 	}
 	if err != nil {
@@ -152,27 +140,40 @@ func (ac *AuthController) Login(c *fiber.Ctx) error {
 	}
 
 	if user.IsBlocked {
+		recordAudit(ac.State, c, loginAudit(&user.ID, email, "password", models.AuditFailure, "blocked"))
 		return respondError(c, fiber.StatusForbidden, "Account is blocked")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+	ok, rehash := ac.State.Passwords.Verify(user.Password, password)
+	if !ok {
+		recordAudit(ac.State, c, loginAudit(&user.ID, email, "password", models.AuditFailure, "bad_password"))
+		if locked := guard.recordFailure(ctx, &user.ID); locked > 0 {
+			return tooManyAttempts(c, locked)
+		}
 		return respondError(c, fiber.StatusBadRequest, "Invalid credentials")
 	}
+	if rehash {
+		ac.upgradePasswordHash(ctx, &user, password)
+	}
 
-	token, err := signToken(ac.State.Config.JWTSecret, user.ID, user.Role)
-	if err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	if user.TwoFactor.Enabled {
+		challenge, err := signChallenge(ac.State.Config.JWTSecret, user.ID)
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Login failed")
+		}
+		return c.JSON(twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(challengeTTL.Seconds()),
+		})
 	}
 
-	resp := authResponse{
-		Token: token,
-		User: authUserResponse{
-			ID:       user.ID.Hex(),
-			Username: user.Username,
-			Email:    user.Email,
-			Role:     user.Role,
-		},
+	guard.recordSuccess(ctx)
+	resp, err := ac.issueTokens(ctx, c, &user, false)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
 	}
+	recordAudit(ac.State, c, loginAudit(&user.ID, email, "password", models.AuditSuccess, ""))
 	return c.JSON(resp)
 }
 
@@ -197,18 +198,118 @@ func (ac *AuthController) GetMe(c *fiber.Ctx) error {
 	return c.JSON(user.Public())
 }
 
+// createAccount inserts a new user with the default role and profile and
+// creates their empty bento page.
+func createAccount(ctx context.Context, state *app.State, user *models.User) error {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	user.ID = primitive.NewObjectID()
+	if user.FullName == nil {
+		fullName := user.Username
+		user.FullName = &fullName
+	}
+	if user.AvatarURL == nil {
+		avatar := ""
+		user.AvatarURL = &avatar
+	}
+	user.Role = models.RoleUser
+	user.IsBlocked = false
+	user.CreatedAt = &now
+	user.UpdatedAt = &now
+
+	if _, err := state.Mongo.Users().InsertOne(ctx, user); err != nil {
+		return err
+	}
+
+	configDoc := models.BentoConfig{
+		User:      user.ID,
+		Username:  user.Username,
+		Widgets:   []models.Widget{},
+		Layouts:   map[string]interface{}{},
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+	_, _ = state.Mongo.BentoConfigs().InsertOne(ctx, configDoc)
+	return nil
+}
+
+// issueTokens opens a new session for the user and returns an access token
+// bound to it together with the session's first refresh token. mfa records
+// whether the login passed a second factor.
+func (ac *AuthController) issueTokens(ctx context.Context, c *fiber.Ctx, user *models.User, mfa bool) (authResponse, error) {
+	now := time.Now()
+	if user.DeleteAfter != nil {
+		// Signing back in during the grace window cancels a pending deletion.
+		res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+			bson.M{"_id": user.ID, "delete_after": bson.M{"$gt": primitive.NewDateTimeFromTime(now)}},
+			bson.M{"$unset": bson.M{"delete_after": ""}},
+		)
+		if err != nil {
+			return authResponse{}, err
+		}
+		if res.MatchedCount == 0 {
+			return authResponse{}, errAccountDeleted
+		}
+		user.DeleteAfter = nil
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return authResponse{}, err
+	}
+
+	session := models.Session{
+		ID:          primitive.NewObjectID(),
+		User:        user.ID,
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   c.Get("User-Agent"),
+		IPHash:      hashToken(c.IP()),
+		MFA:         mfa,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		LastUsedAt:  primitive.NewDateTimeFromTime(now),
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(ac.State.Config.RefreshTokenTTL)),
+	}
+	if _, err := ac.State.Mongo.Sessions().InsertOne(ctx, session); err != nil {
+		return authResponse{}, err
+	}
+
+	return ac.tokenResponse(user, &session, refreshToken)
+}
+
+func (ac *AuthController) tokenResponse(user *models.User, session *models.Session, refreshToken string) (authResponse, error) {
+	ttl := ac.State.Config.AccessTokenTTL
+	token, err := signToken(ac.State.Keys, ttl, session, user.Role)
+	if err != nil {
+		return authResponse{}, err
+	}
+	return authResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(ttl.Seconds()),
+		User: authUserResponse{
+			ID:       user.ID.Hex(),
+			Username: user.Username,
+			Email:    user.Email,
+			Role:     user.Role,
+		},
+	}, nil
+}
+
 // Helper functions that used to be in routes/auth.go
-func signToken(secret string, id primitive.ObjectID, role string) (string, error) {
-	exp := time.Now().Add(7 * 24 * time.Hour)
+func signToken(keys *services.KeyRing, ttl time.Duration, session *models.Session, role string) (string, error) {
+	exp := time.Now().Add(ttl)
 	claims := middleware.Claims{
-		ID:   id.Hex(),
-		Role: role,
+		ID:        session.User.Hex(),
+		Role:      role,
+		SessionID: session.ID.Hex(),
+		MFA:       session.MFA,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer(),
+			Subject:   session.User.Hex(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(exp),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return keys.Sign(claims)
 }
 
 func getString(val *string) string {
@@ -223,3 +324,24 @@ func getString(val *string) string {
 func respondError(c *fiber.Ctx, status int, message string) error {
 	return c.Status(status).JSON(fiber.Map{"message": message})
 }
+
+// upgradePasswordHash re-hashes a verified password with the current
+// algorithm and cost. The update is guarded on the old hash so it can't undo
+// a password reset that lands in between; failures only mean the upgrade
+// waits for the next login.
+func (ac *AuthController) upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	hashed, err := ac.State.Passwords.Hash(password)
+	if err != nil {
+		log.Printf("password rehash %s: %v", user.ID.Hex(), err)
+		return
+	}
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID, "password": user.Password},
+		bson.M{"$set": bson.M{"password": hashed}},
+	)
+	if err != nil {
+		log.Printf("password rehash %s: %v", user.ID.Hex(), err)
+		return
+	}
+	user.Password = hashed
+}
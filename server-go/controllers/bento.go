@@ -5,7 +5,11 @@ import (
 	"brolink-server/middleware"
 	"brolink-server/models"
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,6 +19,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	errBentoConflict = errors.New("bento version conflict")
+	errUnverified    = errors.New("email not verified")
+)
+
 type BentoController struct {
 	State *app.State
 }
@@ -22,13 +31,21 @@ type BentoController struct {
 type syncPayload struct {
 	Widgets []models.Widget        `json:"widgets"`
 	Layouts map[string]interface{} `json:"layouts"`
+	Version *int64                 `json:"version"`
 }
 
+// GetBento serves a user's published page: the default one, or the named
+// page at /bento/:username/:slug. Widgets outside their visibility window
+// are left out, and every page served counts as a view.
 func (bc *BentoController) GetBento(c *fiber.Ctx) error {
 	username := c.Params("username")
 	if username == "" {
 		return respondError(c, fiber.StatusBadRequest, "Username is required")
 	}
+	slug := c.Params("slug")
+	if slug != "" && !validPageSlug.MatchString(slug) {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -36,6 +53,13 @@ func (bc *BentoController) GetBento(c *fiber.Ctx) error {
 	var user models.User
 	err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"username": username}).Decode(&user)
 	if err == mongo.ErrNoDocuments {
+		if current, ok := bc.renamedTo(ctx, username); ok {
+			location := "/api/bento/" + url.PathEscape(current)
+			if slug != "" {
+				location += "/" + slug
+			}
+			return c.Redirect(location, fiber.StatusFound)
+		}
 		return respondError(c, fiber.StatusNotFound, "User not found")
 	}
 	if err != nil {
@@ -45,82 +69,260 @@ func (bc *BentoController) GetBento(c *fiber.Ctx) error {
 	if user.IsBlocked {
 		return respondError(c, fiber.StatusForbidden, "User is blocked")
 	}
+	if user.DeleteAfter != nil {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+
+	config, err := publicPage(ctx, bc.State, &user, slug)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
 
-	cacheKey := fmt.Sprintf("bento:%s", username)
-	if bc.State.Redis != nil {
+	recordPageView(c, bc.State, &user, slug)
+	c.Set(fiber.HeaderETag, bentoETag(config.Version))
+	return c.JSON(config)
+}
+
+// publicPage returns user's published page as visitors currently see it,
+// from the cache when possible. A default page that was never published
+// reads as empty; a missing named page is ErrNoDocuments.
+func publicPage(ctx context.Context, state *app.State, user *models.User, slug string) (*models.BentoConfig, error) {
+	cacheKey := bentoCacheKey(user.Username, slug)
+	if state.Redis != nil {
 		var cached models.BentoConfig
-		if ok, _ := bc.State.Redis.GetJSON(ctx, cacheKey, &cached); ok {
-			return c.JSON(cached)
+		if ok, _ := state.Redis.GetJSON(ctx, cacheKey, &cached); ok {
+			return &cached, nil
 		}
 	}
 
 	var config models.BentoConfig
-	err = bc.State.Mongo.BentoConfigs().FindOne(ctx, bson.M{"username": username}).Decode(&config)
+	err := state.Mongo.BentoConfigs().FindOne(ctx, pageFilter(user.ID, slug)).Decode(&config)
+	if err == mongo.ErrNoDocuments && slug != "" {
+		return nil, err
+	}
 	if err == mongo.ErrNoDocuments {
 		config = models.BentoConfig{
 			User:     user.ID,
-			Username: username,
+			Username: user.Username,
 			Widgets:  []models.Widget{},
 			Layouts:  map[string]interface{}{},
 		}
 	} else if err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+		return nil, err
 	}
 
-	if bc.State.Redis != nil {
-		_ = bc.State.Redis.SetJSON(ctx, cacheKey, &config, 60*time.Second)
+	visible, ttl := visibleBento(&config, time.Now())
+	if state.Redis != nil {
+		_ = state.Redis.SetJSON(ctx, cacheKey, visible, ttl)
 	}
-
-	return c.JSON(config)
+	return visible, nil
 }
 
+// SyncBento replaces the current user's draft of the page named by ?page=
+// (the default page if omitted). The client must send the
+// version it last saw, as If-Match or a version field; if someone else has
+// synced since, nothing is written and the current draft comes back with a
+// 409. "If-Match: *" overwrites unconditionally.
 func (bc *BentoController) SyncBento(c *fiber.Ctx) error {
 	userCtx, ok := middleware.CurrentUser(c)
 	if !ok {
 		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
 	}
 
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
 	var payload syncPayload
 	if err := c.BodyParser(&payload); err != nil {
 		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
 	}
+	if errs := validateBento(&payload, bc.State.Config.BentoMaxWidgets); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid bento",
+			"errors":  errs,
+		})
+	}
+	expected, force, err := expectedVersion(c, payload.Version)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if expected < 0 && !force {
+		return respondError(c, fiber.StatusPreconditionRequired, "If-Match header or version is required")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var dbUser models.User
-	err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"_id": userCtx.ID}).Decode(&dbUser)
-	if err == mongo.ErrNoDocuments {
-		return respondError(c, fiber.StatusNotFound, "User not found")
+	user, err := bc.findPublisher(ctx, userCtx.ID)
+	if err != nil {
+		return respondPublisherError(c, err)
+	}
+
+	updated, err := bc.writeBento(ctx, user, userCtx.ID, slug, payload.Widgets, payload.Layouts, expected, force, nil)
+	if err == errPageNotFound {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err == errBentoConflict {
+		return bc.respondConflict(ctx, c, userCtx.ID, slug)
 	}
 	if err != nil {
-		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+		return respondError(c, fiber.StatusInternalServerError, "Sync failed")
 	}
 
+	c.Set(fiber.HeaderETag, bentoETag(updated.Version))
+	return c.JSON(updated)
+}
+
+// writeBento replaces the draft of user's page slug if it is still at
+// version expected (or unconditionally when force is set) and records the
+// result as a revision by author. It returns errBentoConflict when the
+// draft has moved on, and errPageNotFound for a named page that was never
+// created. The live page only changes on publish.
+func (bc *BentoController) writeBento(
+	ctx context.Context,
+	user *models.User,
+	author primitive.ObjectID,
+	slug string,
+	widgets []models.Widget,
+	layouts map[string]interface{},
+	expected int64,
+	force bool,
+	restoredFrom *primitive.ObjectID,
+) (*models.BentoConfig, error) {
 	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"username":  dbUser.Username,
-			"widgets":   payload.Widgets,
-			"layouts":   payload.Layouts,
+			"username":  user.Username,
+			"widgets":   widgets,
+			"layouts":   layouts,
 			"updatedAt": primitive.NewDateTimeFromTime(now),
 		},
+		"$inc": bson.M{"version": 1},
 		"$setOnInsert": bson.M{
-			"user":      userCtx.ID,
+			"user":      user.ID,
 			"createdAt": primitive.NewDateTimeFromTime(now),
 		},
 	}
 
-	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	// Only a client that has never seen the default page (version 0) may
+	// create it; a stale version against a missing page is a conflict like
+	// any other. Named pages are only created through CreatePage.
+	filter := pageFilter(user.ID, slug)
+	upsert := slug == "" && (force || expected == 0)
+	if !force {
+		if expected == 0 {
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		} else {
+			filter["version"] = expected
+		}
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(upsert).SetReturnDocument(options.After)
 	var updated models.BentoConfig
-	err = bc.State.Mongo.BentoConfigs().FindOneAndUpdate(ctx, bson.M{"user": userCtx.ID}, update, opts).Decode(&updated)
+	err := bc.State.Mongo.BentoDrafts().FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments && slug != "" {
+		exists, countErr := bc.State.Mongo.BentoDrafts().CountDocuments(ctx, pageFilter(user.ID, slug))
+		if countErr == nil && exists == 0 {
+			return nil, errPageNotFound
+		}
+	}
+	if err == mongo.ErrNoDocuments || mongo.IsDuplicateKeyError(err) {
+		return nil, errBentoConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordRevision(ctx, bc.State, &updated, author, restoredFrom)
+	return &updated, nil
+}
+
+// findPublisher loads the user behind a page write and checks they are
+// allowed to publish.
+func (bc *BentoController) findPublisher(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	if err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	if bc.State.Config.RequireVerifiedMail && !user.EmailVerified {
+		return nil, errUnverified
+	}
+	return &user, nil
+}
+
+func respondPublisherError(c *fiber.Ctx, err error) error {
+	switch err {
+	case mongo.ErrNoDocuments:
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	case errUnverified:
+		return respondError(c, fiber.StatusForbidden, "Verify your email before publishing")
+	}
+	return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+}
+
+// respondConflict answers a write that lost a race with the draft as it is
+// now, so the editor can merge or reload.
+func (bc *BentoController) respondConflict(ctx context.Context, c *fiber.Ctx, userID primitive.ObjectID, slug string) error {
+	current, err := bc.findDraft(ctx, userID, slug)
 	if err != nil {
 		return respondError(c, fiber.StatusInternalServerError, "Sync failed")
 	}
+	c.Set(fiber.HeaderETag, bentoETag(current.Version))
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"message": "Page was changed elsewhere",
+		"current": current,
+	})
+}
 
-	if bc.State.Redis != nil {
-		_ = bc.State.Redis.Del(ctx, fmt.Sprintf("bento:%s", dbUser.Username))
+func bentoETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// expectedVersion reads the page version the client is editing from
+// If-Match, falling back to the body's version field. It returns -1 when
+// neither is set, and force when If-Match is "*".
+func expectedVersion(c *fiber.Ctx, bodyVersion *int64) (int64, bool, error) {
+	ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if ifMatch == "*" {
+		return 0, true, nil
+	}
+	if ifMatch != "" {
+		tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil || version < 0 {
+			return 0, false, fmt.Errorf("If-Match must be a page ETag")
+		}
+		return version, false, nil
 	}
+	if bodyVersion != nil {
+		if *bodyVersion < 0 {
+			return 0, false, fmt.Errorf("version must not be negative")
+		}
+		return *bodyVersion, false, nil
+	}
+	return -1, false, nil
+}
 
-	return c.JSON(updated)
+// renamedTo returns the current username for an old handle that is still
+// inside its redirect window.
+func (bc *BentoController) renamedTo(ctx context.Context, username string) (string, bool) {
+	var redirect models.UsernameRedirect
+	err := bc.State.Mongo.UsernameRedirects().FindOne(ctx, bson.M{
+		"username":   username,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}).Decode(&redirect)
+	if err != nil {
+		return "", false
+	}
+
+	var user models.User
+	if err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"_id": redirect.User}).Decode(&user); err != nil {
+		return "", false
+	}
+	return user.Username, true
 }
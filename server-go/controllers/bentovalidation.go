@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"brolink-server/models"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+)
+
+const maxLayoutRow = 10000
+
+// layoutKeys are the react-grid-layout item fields kept when a layout is
+// stored; anything else the client sends is dropped.
+var layoutKeys = map[string]bool{
+	"i": true, "x": true, "y": true, "w": true, "h": true,
+	"minW": true, "minH": true, "maxW": true, "maxH": true,
+	"moved": true, "static": true,
+}
+
+// fieldError is one validation problem, keyed by the path of the offending
+// field in the request body, e.g. "widgets[2].url".
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// bentoValidator collects every problem in a sync payload so the editor
+// can show them all at once instead of one per request.
+type bentoValidator struct {
+	errors []fieldError
+}
+
+func (v *bentoValidator) add(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *bentoValidator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *bentoValidator) maxLen(field, value string, max int) {
+	if len(value) > max {
+		v.add(field, "must be at most %d characters", max)
+	}
+}
+
+// url checks raw is an absolute URL using one of schemes, or http(s) when
+// schemes is nil.
+func (v *bentoValidator) url(field, raw string, schemes []string) {
+	if len(raw) > maxWidgetURLLen {
+		v.add(field, "must be at most %d characters", maxWidgetURLLen)
+		return
+	}
+	if schemes == nil {
+		schemes = []string{"http", "https"}
+	}
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.Scheme == "" {
+		v.add(field, "must be a valid URL")
+		return
+	}
+	allowed := false
+	for _, scheme := range schemes {
+		if strings.EqualFold(parsed.Scheme, scheme) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		v.add(field, "must use one of %s", strings.Join(schemes, ", "))
+		return
+	}
+	if (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host == "" {
+		v.add(field, "must be a valid URL")
+	}
+}
+
+// validateBento checks a sync payload in place: widgets are normalized to
+// their type's schema and layouts are rebuilt keeping only known fields.
+// It returns every problem found.
+func validateBento(payload *syncPayload, maxWidgets int) []fieldError {
+	v := &bentoValidator{}
+
+	if len(payload.Widgets) > maxWidgets {
+		v.add("widgets", "allows at most %d widgets", maxWidgets)
+		return v.errors
+	}
+
+	ids := make(map[string]bool, len(payload.Widgets))
+	for i := range payload.Widgets {
+		field := indexed("widgets", i)
+		normalizeWidget(v, field, &payload.Widgets[i])
+		id := payload.Widgets[i].ID
+		if id == "" {
+			continue
+		}
+		if ids[id] {
+			v.add(field+".id", "duplicates another widget")
+		}
+		ids[id] = true
+	}
+
+	payload.Layouts = validateLayouts(v, payload.Layouts, ids)
+	return v.errors
+}
+
+// validateLayouts checks every breakpoint layout only places widgets that
+// exist, once each, and pulls items that are too wide for a breakpoint back
+// inside its grid.
+func validateLayouts(v *bentoValidator, layouts map[string]interface{}, ids map[string]bool) map[string]interface{} {
+	clean := make(map[string]interface{}, len(layouts))
+	for breakpoint, raw := range layouts {
+		field := "layouts." + breakpoint
+		cols, ok := models.LayoutBreakpoints[breakpoint]
+		if !ok {
+			v.add(field, "is not a known breakpoint")
+			continue
+		}
+		items, ok := raw.([]interface{})
+		if !ok {
+			v.add(field, "must be a list")
+			continue
+		}
+
+		placed := make(map[string]bool, len(items))
+		out := make([]interface{}, 0, len(items))
+		for i, rawItem := range items {
+			itemField := indexed(field, i)
+			item, ok := rawItem.(map[string]interface{})
+			if !ok {
+				v.add(itemField, "must be an object")
+				continue
+			}
+
+			id, _ := item["i"].(string)
+			switch {
+			case !ids[id]:
+				v.add(itemField+".i", "does not match any widget")
+			case placed[id]:
+				v.add(itemField+".i", "places the same widget twice")
+			}
+			placed[id] = true
+
+			x, okX := gridNumber(item["x"])
+			y, okY := gridNumber(item["y"])
+			w, okW := gridNumber(item["w"])
+			h, okH := gridNumber(item["h"])
+			if !okX || x < 0 {
+				v.add(itemField+".x", "must be a non-negative integer")
+			}
+			if !okY || y < 0 || y > maxLayoutRow {
+				v.add(itemField+".y", "must be an integer between 0 and %d", maxLayoutRow)
+			}
+			// The editor sizes a widget once for every breakpoint, so a wide
+			// widget is narrowed to fit the smaller grids rather than refused.
+			if !okW || w < 1 {
+				v.add(itemField+".w", "must be a positive integer")
+			} else if w > cols {
+				w = cols
+				item["w"] = float64(w)
+			}
+			if okX && okW && w >= 1 && x+w > cols {
+				x = cols - w
+				item["x"] = float64(x)
+			}
+			if minW, ok := gridNumber(item["minW"]); ok && minW > cols {
+				item["minW"] = float64(cols)
+			}
+			if !okH || h < 1 || h > 4 {
+				v.add(itemField+".h", "must be an integer between 1 and 4")
+			}
+
+			kept := make(map[string]interface{}, len(layoutKeys))
+			for key, value := range item {
+				if layoutKeys[key] {
+					kept[key] = value
+				}
+			}
+			out = append(out, kept)
+		}
+		clean[breakpoint] = out
+	}
+	return clean
+}
+
+// gridNumber reads a JSON number that must hold a whole grid coordinate.
+func gridNumber(raw interface{}) (int, bool) {
+	f, ok := raw.(float64)
+	if !ok || f != math.Trunc(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func indexed(field string, i int) string {
+	return fmt.Sprintf("%s[%d]", field, i)
+}
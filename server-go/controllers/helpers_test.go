@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/config"
+	"brolink-server/db"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestState builds an app.State on a throwaway database in the MongoDB
+// at MONGODB_TEST_URI and an in-memory Redis. Tests that need it are
+// skipped when MONGODB_TEST_URI is unset.
+func newTestState(t *testing.T) *app.State {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect mongo: %v", err)
+	}
+	database := client.Database("brolink_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = database.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+
+	mr := miniredis.RunT(t)
+
+	cfg := config.Load()
+	cfg.AppEnv = "development"
+	cfg.JWTSecret = "test-secret"
+	cfg.JWTAlgorithm = config.JWTAlgHS256
+	cfg.PasswordHash = config.PasswordHashBcrypt
+	cfg.BcryptCost = 4
+	cfg.ServerURL = "http://api.test"
+	cfg.ClientURL = "http://app.test"
+	cfg.OAuthProviders = map[string]config.OAuthProvider{}
+
+	keys, err := services.NewKeyRing(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("key ring: %v", err)
+	}
+	passwords, err := services.NewPasswords(cfg)
+	if err != nil {
+		t.Fatalf("passwords: %v", err)
+	}
+
+	return &app.State{
+		Config:    cfg,
+		Mongo:     &db.Mongo{Client: client, DB: database},
+		Redis:     &db.Redis{Client: redis.NewClient(&redis.Options{Addr: mr.Addr()})},
+		Mailer:    &services.LocalMailer{Dir: t.TempDir(), From: cfg.MailFrom},
+		Keys:      keys,
+		Passwords: passwords,
+	}
+}
+
+// insertUser creates an account the way signup does, then applies edit
+// to it and saves the result.
+func insertUser(t *testing.T, state *app.State, username, email string, edit func(*models.User)) *models.User {
+	t.Helper()
+	hashed, err := state.Passwords.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, Email: email, Password: hashed}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := createAccount(ctx, state, user); err != nil {
+		t.Fatalf("create %s: %v", username, err)
+	}
+	if edit != nil {
+		edit(user)
+		if _, err := state.Mongo.Users().ReplaceOne(ctx, bson.M{"_id": user.ID}, user); err != nil {
+			t.Fatalf("update %s: %v", username, err)
+		}
+	}
+	return user
+}
+
+func loadUser(t *testing.T, state *app.State, id primitive.ObjectID) models.User {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var user models.User
+	if err := state.Mongo.Users().FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	return user
+}
+
+// do sends a request to app and returns the response with its body read.
+func do(t *testing.T, app *fiber.App, method, target string, body interface{}, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = strings.NewReader(string(raw))
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := app.Test(req, 15000)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, raw
+}
@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	"brolink-server/app"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type KeysController struct {
+	State *app.State
+}
+
+// JWKS publishes the public keys that verify BroLink access tokens.
+func (kc *KeysController) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(kc.State.Keys.JWKS())
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/models"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxLockout       = 24 * time.Hour
+	lockoutMemory    = 24 * time.Hour
+	baseFailureDelay = 250 * time.Millisecond
+	maxFailureDelay  = 4 * time.Second
+	freeFailures     = 2
+)
+
+// loginGuard throttles password and second-factor attempts with sliding
+// window counters in Redis, keyed both by client IP and by account email.
+// It fails open when Redis is unavailable.
+type loginGuard struct {
+	state     *app.State
+	ipHash    string
+	account   string
+	email     string
+	userAgent string
+}
+
+func newLoginGuard(state *app.State, c *fiber.Ctx, email string) *loginGuard {
+	email = strings.ToLower(strings.TrimSpace(email))
+	return &loginGuard{
+		state:     state,
+		ipHash:    hashToken(c.IP()),
+		account:   hashToken(email),
+		email:     email,
+		userAgent: c.Get("User-Agent"),
+	}
+}
+
+// retryAfter returns how long the caller is still locked out for.
+func (g *loginGuard) retryAfter(ctx context.Context) time.Duration {
+	if g.state.Redis == nil {
+		return 0
+	}
+	var wait time.Duration
+	for _, key := range []string{lockKey("ip", g.ipHash), lockKey("acct", g.account)} {
+		ttl, err := g.state.Redis.TTL(ctx, key)
+		if err != nil {
+			log.Printf("login guard: %v", err)
+			continue
+		}
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait
+}
+
+// recordFailure counts a failed attempt against the IP and the account and
+// locks out whichever crossed its limit. Otherwise it stalls the response
+// for a delay that doubles with each recent failure. The returned duration
+// is non-zero when this attempt triggered a lockout.
+func (g *loginGuard) recordFailure(ctx context.Context, userID *primitive.ObjectID) time.Duration {
+	if g.state.Redis == nil {
+		return 0
+	}
+	cfg := g.state.Config
+	now := time.Now()
+
+	ipCount, err := g.state.Redis.SlidingWindowAdd(ctx, failKey("ip", g.ipHash), now, cfg.LoginWindow)
+	if err != nil {
+		log.Printf("login guard: %v", err)
+		return 0
+	}
+	acctCount, err := g.state.Redis.SlidingWindowAdd(ctx, failKey("acct", g.account), now, cfg.LoginWindow)
+	if err != nil {
+		log.Printf("login guard: %v", err)
+		return 0
+	}
+
+	var locked time.Duration
+	if acctCount >= int64(cfg.LoginMaxAttempts) {
+		if d := g.lock(ctx, "acct", g.account, acctCount, userID); d > locked {
+			locked = d
+		}
+	}
+	if ipCount >= int64(cfg.LoginMaxAttemptsIP) {
+		if d := g.lock(ctx, "ip", g.ipHash, ipCount, nil); d > locked {
+			locked = d
+		}
+	}
+	if locked > 0 {
+		return locked
+	}
+
+	if acctCount > freeFailures {
+		delay := baseFailureDelay << uint(acctCount-freeFailures-1)
+		if delay > maxFailureDelay || delay <= 0 {
+			delay = maxFailureDelay
+		}
+		time.Sleep(delay)
+	}
+	return 0
+}
+
+// recordSuccess clears the account's failure window. The IP window is left
+// alone so one valid login can't reset a spray across many accounts.
+func (g *loginGuard) recordSuccess(ctx context.Context) {
+	if g.state.Redis == nil {
+		return
+	}
+	_ = g.state.Redis.Del(ctx, failKey("acct", g.account))
+}
+
+// lock applies a lockout that doubles for each lockout of the same key in
+// the last day, and records it for admins.
+func (g *loginGuard) lock(ctx context.Context, scope, key string, attempts int64, userID *primitive.ObjectID) time.Duration {
+	strikes, err := g.state.Redis.Incr(ctx, fmt.Sprintf("login:strikes:%s:%s", scope, key), lockoutMemory)
+	if err != nil {
+		log.Printf("login guard: %v", err)
+		strikes = 1
+	}
+	duration := g.state.Config.LoginLockout * time.Duration(math.Pow(2, float64(strikes-1)))
+	if duration > maxLockout || duration <= 0 {
+		duration = maxLockout
+	}
+	if err := g.state.Redis.SetFlag(ctx, lockKey(scope, key), duration); err != nil {
+		log.Printf("login guard: %v", err)
+		return 0
+	}
+	_ = g.state.Redis.Del(ctx, failKey(scope, key))
+
+	now := time.Now()
+	event := models.LockoutEvent{
+		ID:          primitive.NewObjectID(),
+		Scope:       "ip",
+		IPHash:      g.ipHash,
+		UserAgent:   g.userAgent,
+		Attempts:    attempts,
+		LockedUntil: primitive.NewDateTimeFromTime(now.Add(duration)),
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+	}
+	if scope == "acct" {
+		event.Scope = "account"
+		event.Email = g.email
+		event.User = userID
+	}
+	if _, err := g.state.Mongo.LockoutEvents().InsertOne(ctx, event); err != nil {
+		log.Printf("login guard: record lockout: %v", err)
+	}
+	return duration
+}
+
+// clearAccountLockout lifts an account lockout and forgets its history.
+func clearAccountLockout(ctx context.Context, state *app.State, email string) error {
+	if state.Redis == nil {
+		return nil
+	}
+	account := hashToken(strings.ToLower(strings.TrimSpace(email)))
+	return state.Redis.Client.Del(ctx,
+		lockKey("acct", account),
+		failKey("acct", account),
+		fmt.Sprintf("login:strikes:acct:%s", account),
+	).Err()
+}
+
+func failKey(scope, key string) string {
+	return fmt.Sprintf("login:fail:%s:%s", scope, key)
+}
+
+func lockKey(scope, key string) string {
+	return fmt.Sprintf("login:lock:%s:%s", scope, key)
+}
+
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"message":     "Too many login attempts. Try again later.",
+		"retry_after": seconds,
+	})
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	magicLinkTTL        = 15 * time.Minute
+	magicLinkWindow     = 15 * time.Minute
+	magicLinkMaxPerMail = 3
+	magicLinkMaxPerIP   = 10
+)
+
+type magicLinkPayload struct {
+	Email string `json:"email"`
+}
+
+type magicLinkLogin struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// RequestMagicLink emails a one-time login link. Like ForgotPassword it
+// answers the same way whether or not the address has an account; requests
+// are rate limited per address and per IP.
+func (ac *AuthController) RequestMagicLink(c *fiber.Ctx) error {
+	var payload magicLinkPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	email := strings.TrimSpace(payload.Email)
+	if email == "" {
+		return respondError(c, fiber.StatusBadRequest, "email is required")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Magic links unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if wait := ac.magicLinkThrottle(ctx, c, email); wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"message":     "Too many login links requested. Try again later.",
+			"retry_after": seconds,
+		})
+	}
+
+	resp := fiber.Map{"message": "If that email is registered, a login link has been sent"}
+
+	var user models.User
+	err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.IsBlocked) {
+		return c.JSON(resp)
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+	stored := magicLinkLogin{UserID: user.ID.Hex(), Email: user.Email}
+	if err := ac.State.Redis.SetJSON(ctx, magicLinkKey(token), stored, magicLinkTTL); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+
+	link := fmt.Sprintf("%s/magic-link?token=%s", ac.State.Config.ClientURL, url.QueryEscape(token))
+	sendMail(ac.State, services.MailMessage{
+		To:      user.Email,
+		Subject: "Your BroLink login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link within 15 minutes to log in to BroLink:\n\n%s\n\n"+
+			"It works once. If you didn't ask for it, you can ignore this email.\n", user.Username, link),
+	})
+
+	return c.JSON(resp)
+}
+
+// ConsumeMagicLink trades a login link token for the usual access and
+// refresh tokens, or for a 2FA challenge when the account has it enabled.
+func (ac *AuthController) ConsumeMagicLink(c *fiber.Ctx) error {
+	var payload verifyEmailPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	token := strings.TrimSpace(payload.Token)
+	if token == "" {
+		return respondError(c, fiber.StatusBadRequest, "token is required")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Magic links unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var login magicLinkLogin
+	found, err := ac.State.Redis.TakeJSON(ctx, magicLinkKey(token), &login)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	if !found {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired link")
+	}
+	userID, err := primitive.ObjectIDFromHex(login.UserID)
+	if err != nil {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired link")
+	}
+
+	user, err := ac.findUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired link")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	// The link was sent to a specific address; it dies if the email changed.
+	if user.Email != login.Email {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired link")
+	}
+	if user.IsBlocked {
+		return respondError(c, fiber.StatusForbidden, "Account is blocked")
+	}
+
+	// Following the link proves the user controls the inbox.
+	if !user.EmailVerified {
+		_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+			bson.M{"_id": user.ID, "email": login.Email},
+			bson.M{"$set": bson.M{"email_verified": true}},
+		)
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Login failed")
+		}
+		user.EmailVerified = true
+	}
+
+	if user.TwoFactor.Enabled {
+		challenge, err := signChallenge(ac.State.Config.JWTSecret, user.ID)
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Login failed")
+		}
+		return c.JSON(twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(challengeTTL.Seconds()),
+		})
+	}
+
+	resp, err := ac.issueTokens(ctx, c, user, false)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "magic_link", models.AuditSuccess, ""))
+	return c.JSON(resp)
+}
+
+// magicLinkThrottle counts this request against the address and the IP and
+// returns how long to wait when either is over its limit. It fails open on
+// Redis errors.
+func (ac *AuthController) magicLinkThrottle(ctx context.Context, c *fiber.Ctx, email string) time.Duration {
+	now := time.Now()
+	limits := []struct {
+		key string
+		max int64
+	}{
+		{fmt.Sprintf("magic:rate:acct:%s", hashToken(strings.ToLower(email))), magicLinkMaxPerMail},
+		{fmt.Sprintf("magic:rate:ip:%s", hashToken(c.IP())), magicLinkMaxPerIP},
+	}
+
+	var wait time.Duration
+	for _, limit := range limits {
+		count, err := ac.State.Redis.SlidingWindowAdd(ctx, limit.key, now, magicLinkWindow)
+		if err != nil {
+			log.Printf("magic link throttle: %v", err)
+			continue
+		}
+		if count > limit.max && magicLinkWindow > wait {
+			wait = magicLinkWindow
+		}
+	}
+	return wait
+}
+
+func magicLinkKey(token string) string {
+	return "magic:token:" + hashToken(token)
+}
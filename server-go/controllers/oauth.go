@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	oauthStateTTL = 10 * time.Minute
+	oauthCodeTTL  = 1 * time.Minute
+)
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+type oauthState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+type oauthLogin struct {
+	UserID string `json:"user_id"`
+}
+
+type oauthExchangePayload struct {
+	Code string `json:"code"`
+}
+
+// ListOAuthProviders returns the names of the configured social logins.
+func (ac *AuthController) ListOAuthProviders(c *fiber.Ctx) error {
+	names := make([]string, 0, len(ac.State.Config.OAuthProviders))
+	for name := range ac.State.Config.OAuthProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return c.JSON(names)
+}
+
+// OAuthStart redirects the browser to the provider with a fresh state,
+// nonce and PKCE challenge.
+func (ac *AuthController) OAuthStart(c *fiber.Ctx) error {
+	client, ok := ac.oauthClient(c.Params("provider"))
+	if !ok {
+		return respondError(c, fiber.StatusNotFound, "Unknown provider")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Social login unavailable")
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	verifier, challenge, err := services.NewPKCE()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stored := oauthState{Provider: client.Provider.Name, Verifier: verifier, Nonce: nonce}
+	if err := ac.State.Redis.SetJSON(ctx, "oauth:state:"+state, stored, oauthStateTTL); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+
+	target, err := client.AuthCodeURL(ctx, state, challenge, nonce)
+	if err != nil {
+		log.Printf("oauth %s: %v", client.Provider.Name, err)
+		return respondError(c, fiber.StatusBadGateway, "Provider unavailable")
+	}
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// OAuthCallback finishes the provider round trip, links or creates the
+// account, and sends the browser back to the client with a one-time code
+// for OAuthExchange.
+func (ac *AuthController) OAuthCallback(c *fiber.Ctx) error {
+	client, ok := ac.oauthClient(c.Params("provider"))
+	if !ok {
+		return respondError(c, fiber.StatusNotFound, "Unknown provider")
+	}
+	if ac.State.Redis == nil {
+		return ac.oauthRedirect(c, "error", "unavailable")
+	}
+	if c.Query("error") != "" {
+		return ac.oauthRedirect(c, "error", "access_denied")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var stored oauthState
+	found, err := ac.State.Redis.TakeJSON(ctx, "oauth:state:"+c.Query("state"), &stored)
+	if err != nil || !found || stored.Provider != client.Provider.Name {
+		return ac.oauthRedirect(c, "error", "invalid_state")
+	}
+
+	identity, err := client.Identify(ctx, c.Query("code"), stored.Verifier, stored.Nonce)
+	if err != nil {
+		log.Printf("oauth %s: %v", client.Provider.Name, err)
+		return ac.oauthRedirect(c, "error", "provider_error")
+	}
+
+	user, reason, err := ac.resolveOAuthUser(ctx, client.Provider.Name, identity)
+	if err != nil {
+		log.Printf("oauth %s: %v", client.Provider.Name, err)
+		return ac.oauthRedirect(c, "error", "server_error")
+	}
+	if user == nil {
+		return ac.oauthRedirect(c, "error", reason)
+	}
+	if user.IsBlocked {
+		return ac.oauthRedirect(c, "error", "blocked")
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return ac.oauthRedirect(c, "error", "server_error")
+	}
+	if err := ac.State.Redis.SetJSON(ctx, "oauth:login:"+code, oauthLogin{UserID: user.ID.Hex()}, oauthCodeTTL); err != nil {
+		return ac.oauthRedirect(c, "error", "server_error")
+	}
+	return ac.oauthRedirect(c, "code", code)
+}
+
+// OAuthExchange trades the one-time code from OAuthCallback for tokens, or
+// for a 2FA challenge when the account has it enabled.
+func (ac *AuthController) OAuthExchange(c *fiber.Ctx) error {
+	var payload oauthExchangePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Code) == "" {
+		return respondError(c, fiber.StatusBadRequest, "code is required")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Social login unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var login oauthLogin
+	found, err := ac.State.Redis.TakeJSON(ctx, "oauth:login:"+strings.TrimSpace(payload.Code), &login)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	if !found {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired code")
+	}
+	userID, err := primitive.ObjectIDFromHex(login.UserID)
+	if err != nil {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired code")
+	}
+
+	user, err := ac.findUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired code")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	if user.IsBlocked {
+		return respondError(c, fiber.StatusForbidden, "Account is blocked")
+	}
+
+	if user.TwoFactor.Enabled {
+		challenge, err := signChallenge(ac.State.Config.JWTSecret, user.ID)
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Login failed")
+		}
+		return c.JSON(twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(challengeTTL.Seconds()),
+		})
+	}
+
+	resp, err := ac.issueTokens(ctx, c, user, false)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "oauth", models.AuditSuccess, ""))
+	return c.JSON(resp)
+}
+
+// resolveOAuthUser finds the account linked to the identity, links it to an
+// existing account with the same verified email, or creates a new account.
+// A nil user with a reason means the login must be refused.
+//
+// An existing account is only linked if it has verified its own email;
+// otherwise whoever registered it may not own the address, and linking
+// would hand them the provider user's login.
+func (ac *AuthController) resolveOAuthUser(ctx context.Context, provider string, identity *services.OAuthIdentity) (*models.User, string, error) {
+	users := ac.State.Mongo.Users()
+
+	var user models.User
+	err := users.FindOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{
+		"provider": provider,
+		"subject":  identity.Subject,
+	}}}).Decode(&user)
+	if err == nil {
+		return &user, "", nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, "", err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, "email_unverified", nil
+	}
+
+	link := models.LinkedIdentity{
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	err = users.FindOne(ctx, bson.M{"email": identity.Email}).Decode(&user)
+	if err == nil {
+		if !user.EmailVerified {
+			return nil, "account_exists", nil
+		}
+		_, err = users.UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{
+				"$push": bson.M{"identities": link},
+				"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+			},
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		user.Identities = append(user.Identities, link)
+		return &user, "", nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, "", err
+	}
+
+	username, err := availableUsername(ctx, ac.State, identity.Username, strings.Split(identity.Email, "@")[0])
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Social-only accounts get an unguessable password; they can set a real
+	// one through the forgot-password flow.
+	secret, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	hashed, err := ac.State.Passwords.Hash(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user = models.User{
+		Username:      username,
+		Email:         identity.Email,
+		Password:      hashed,
+		EmailVerified: true,
+		Identities:    []models.LinkedIdentity{link},
+	}
+	if name := strings.TrimSpace(identity.Name); name != "" {
+		user.FullName = &name
+	}
+	if identity.Picture != "" {
+		picture := identity.Picture
+		user.AvatarURL = &picture
+	}
+	if err := createAccount(ctx, ac.State, &user); err != nil {
+		return nil, "", err
+	}
+	return &user, "", nil
+}
+
+// availableUsername returns the first free handle derived from the
+// candidates, adding a numeric suffix if needed.
+func availableUsername(ctx context.Context, state *app.State, candidates ...string) (string, error) {
+	base := ""
+	for _, candidate := range candidates {
+		if base = strings.Trim(usernameUnsafe.ReplaceAllString(strings.ToLower(candidate), ""), "-_"); base != "" {
+			break
+		}
+	}
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 24 {
+		base = base[:24]
+	}
+
+	name := base
+	for i := 0; i < 10; i++ {
+		count, err := state.Mongo.Users().CountDocuments(ctx, bson.M{"username": name})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 && !usernameReserved(name) {
+			return name, nil
+		}
+		suffix, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%s", base, hashToken(suffix)[:4])
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+func (ac *AuthController) oauthClient(name string) (*services.OAuthClient, bool) {
+	provider, ok := ac.State.Config.OAuthProviders[name]
+	if !ok {
+		return nil, false
+	}
+	redirect := fmt.Sprintf("%s/api/auth/oauth/%s/callback", ac.State.Config.ServerURL, url.PathEscape(name))
+	return services.NewOAuthClient(provider, redirect), true
+}
+
+func (ac *AuthController) oauthRedirect(c *fiber.Ctx, key, value string) error {
+	target := fmt.Sprintf("%s/oauth/callback?%s=%s", ac.State.Config.ClientURL, key, url.QueryEscape(value))
+	return c.Redirect(target, fiber.StatusFound)
+}
@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/config"
+	"brolink-server/models"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mockOIDC is a minimal OpenID Connect provider: discovery, JWKS and a
+// token endpoint that checks PKCE and signs ID tokens with the nonce it
+// was given at authorization time.
+type mockOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDC{key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "mock",
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.mu.Lock()
+		grant, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "mock"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": signed})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize plays the user approving the login at the provider: it takes
+// the query the app redirected to and returns an authorization code for
+// an ID token with claims. The nonce from the query is used unless claims
+// sets its own.
+func (p *mockOIDC) authorize(t *testing.T, query url.Values, claims jwt.MapClaims) string {
+	t.Helper()
+	now := time.Now()
+	full := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   query.Get("client_id"),
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code := randomCode(t)
+	p.mu.Lock()
+	p.codes[code] = mockGrant{challenge: query.Get("code_challenge"), claims: full}
+	p.mu.Unlock()
+	return code
+}
+
+func randomCode(t *testing.T) string {
+	t.Helper()
+	token, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+type oauthHarness struct {
+	state    *app.State
+	app      *fiber.App
+	provider *mockOIDC
+}
+
+func newOAuthHarness(t *testing.T) *oauthHarness {
+	t.Helper()
+	state := newTestState(t)
+	provider := newMockOIDC(t)
+	state.Config.OAuthProviders["mock"] = config.OAuthProvider{
+		Name:         "mock",
+		Kind:         "oidc",
+		Issuer:       provider.URL,
+		ClientID:     "brolink",
+		ClientSecret: "shh",
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+
+	ac := &AuthController{State: state}
+	server := fiber.New()
+	server.Get("/auth/oauth/:provider", ac.OAuthStart)
+	server.Get("/auth/oauth/:provider/callback", ac.OAuthCallback)
+	server.Post("/auth/oauth/exchange", ac.OAuthExchange)
+	return &oauthHarness{state: state, app: server, provider: provider}
+}
+
+// start begins a login and returns the query sent to the provider.
+func (h *oauthHarness) start(t *testing.T) url.Values {
+	t.Helper()
+	resp, _ := do(t, h.app, http.MethodGet, "/auth/oauth/mock", nil, nil)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("start: status %d", resp.StatusCode)
+	}
+	target, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target.Query()
+}
+
+// callback returns the query the app sends the browser back to the
+// client with: either code or error.
+func (h *oauthHarness) callback(t *testing.T, state, code string) url.Values {
+	t.Helper()
+	target := "/auth/oauth/mock/callback?" + url.Values{"state": {state}, "code": {code}}.Encode()
+	resp, _ := do(t, h.app, http.MethodGet, target, nil, nil)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("callback: status %d", resp.StatusCode)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return back.Query()
+}
+
+// login runs the whole round trip for an identity with claims.
+func (h *oauthHarness) login(t *testing.T, claims jwt.MapClaims) url.Values {
+	t.Helper()
+	query := h.start(t)
+	return h.callback(t, query.Get("state"), h.provider.authorize(t, query, claims))
+}
+
+func (h *oauthHarness) exchange(t *testing.T, code string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, raw := do(t, h.app, http.MethodPost, "/auth/oauth/exchange", fiber.Map{"code": code}, nil)
+	body := map[string]interface{}{}
+	_ = json.Unmarshal(raw, &body)
+	return resp.StatusCode, body
+}
+
+func wantOAuthError(t *testing.T, back url.Values, reason string) {
+	t.Helper()
+	if back.Get("error") != reason || back.Get("code") != "" {
+		t.Fatalf("want error=%s, got %v", reason, back)
+	}
+}
+
+func TestOAuthStartSendsPKCEAndNonce(t *testing.T) {
+	h := newOAuthHarness(t)
+	query := h.start(t)
+	for _, key := range []string{"state", "nonce", "code_challenge"} {
+		if query.Get(key) == "" {
+			t.Errorf("authorization request has no %s", key)
+		}
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q", query.Get("code_challenge_method"))
+	}
+}
+
+func TestOAuthCallbackRejectsUnknownState(t *testing.T) {
+	h := newOAuthHarness(t)
+	query := h.start(t)
+	code := h.provider.authorize(t, query, jwt.MapClaims{"sub": "s1", "email": "a@example.com", "email_verified": true})
+	wantOAuthError(t, h.callback(t, "not-the-state", code), "invalid_state")
+}
+
+func TestOAuthCallbackRejectsReusedState(t *testing.T) {
+	h := newOAuthHarness(t)
+	query := h.start(t)
+	claims := jwt.MapClaims{"sub": "s1", "email": "a@example.com", "email_verified": true}
+	if back := h.callback(t, query.Get("state"), h.provider.authorize(t, query, claims)); back.Get("code") == "" {
+		t.Fatalf("first callback failed: %v", back)
+	}
+	wantOAuthError(t, h.callback(t, query.Get("state"), h.provider.authorize(t, query, claims)), "invalid_state")
+}
+
+func TestOAuthCallbackRejectsNonceMismatch(t *testing.T) {
+	h := newOAuthHarness(t)
+	back := h.login(t, jwt.MapClaims{
+		"sub":            "s1",
+		"email":          "a@example.com",
+		"email_verified": true,
+		"nonce":          "replayed-nonce",
+	})
+	wantOAuthError(t, back, "provider_error")
+}
+
+// TestOAuthCallbackRejectsInjectedCode checks PKCE: a code issued for
+// another login attempt can't be redeemed with this attempt's state.
+func TestOAuthCallbackRejectsInjectedCode(t *testing.T) {
+	h := newOAuthHarness(t)
+	victim := h.start(t)
+	attacker := h.start(t)
+	code := h.provider.authorize(t, attacker, jwt.MapClaims{
+		"sub":            "s1",
+		"email":          "a@example.com",
+		"email_verified": true,
+		"nonce":          victim.Get("nonce"),
+	})
+	wantOAuthError(t, h.callback(t, victim.Get("state"), code), "provider_error")
+}
+
+func TestOAuthLinksVerifiedAccount(t *testing.T) {
+	h := newOAuthHarness(t)
+	user := insertUser(t, h.state, "ada", "ada@example.com", func(u *models.User) { u.EmailVerified = true })
+
+	back := h.login(t, jwt.MapClaims{"sub": "ada-sub", "email": "ada@example.com", "email_verified": true})
+	if back.Get("code") == "" {
+		t.Fatalf("login failed: %v", back)
+	}
+	status, body := h.exchange(t, back.Get("code"))
+	if status != http.StatusOK || body["token"] == nil {
+		t.Fatalf("exchange: %d %v", status, body)
+	}
+	if got := body["user"].(map[string]interface{})["id"]; got != user.ID.Hex() {
+		t.Fatalf("signed in as %v, want %s", got, user.ID.Hex())
+	}
+
+	linked := loadUser(t, h.state, user.ID)
+	if len(linked.Identities) != 1 || linked.Identities[0].Subject != "ada-sub" {
+		t.Fatalf("identities = %+v", linked.Identities)
+	}
+
+	// The one-time code is spent.
+	if status, _ := h.exchange(t, back.Get("code")); status != http.StatusUnauthorized {
+		t.Fatalf("second exchange: %d", status)
+	}
+}
+
+func TestOAuthDoesNotLinkUnverifiedAccount(t *testing.T) {
+	h := newOAuthHarness(t)
+	user := insertUser(t, h.state, "squatter", "ada@example.com", nil)
+
+	back := h.login(t, jwt.MapClaims{"sub": "ada-sub", "email": "ada@example.com", "email_verified": true})
+	wantOAuthError(t, back, "account_exists")
+
+	if got := loadUser(t, h.state, user.ID); len(got.Identities) != 0 {
+		t.Fatalf("unverified account was linked: %+v", got.Identities)
+	}
+}
+
+func TestOAuthRefusesUnverifiedProviderEmail(t *testing.T) {
+	h := newOAuthHarness(t)
+	insertUser(t, h.state, "ada", "ada@example.com", func(u *models.User) { u.EmailVerified = true })
+
+	back := h.login(t, jwt.MapClaims{"sub": "ada-sub", "email": "ada@example.com", "email_verified": false})
+	wantOAuthError(t, back, "email_unverified")
+}
+
+func TestOAuthRefusesBlockedUser(t *testing.T) {
+	h := newOAuthHarness(t)
+	insertUser(t, h.state, "ada", "ada@example.com", func(u *models.User) {
+		u.EmailVerified = true
+		u.IsBlocked = true
+	})
+
+	back := h.login(t, jwt.MapClaims{"sub": "ada-sub", "email": "ada@example.com", "email_verified": true})
+	wantOAuthError(t, back, "blocked")
+}
+
+func TestOAuthTwoFactorUserGetsChallenge(t *testing.T) {
+	h := newOAuthHarness(t)
+	insertUser(t, h.state, "ada", "ada@example.com", func(u *models.User) {
+		u.EmailVerified = true
+		u.TwoFactor = models.TwoFactor{Enabled: true, Secret: "JBSWY3DPEHPK3PXP"}
+	})
+
+	back := h.login(t, jwt.MapClaims{"sub": "ada-sub", "email": "ada@example.com", "email_verified": true})
+	if back.Get("code") == "" {
+		t.Fatalf("login failed: %v", back)
+	}
+	status, body := h.exchange(t, back.Get("code"))
+	if status != http.StatusOK || body["two_factor_required"] != true || body["challenge_token"] == "" {
+		t.Fatalf("exchange: %d %v", status, body)
+	}
+	if body["token"] != nil {
+		t.Fatal("2FA user got tokens without a second factor")
+	}
+}
+
+func TestOAuthCreatesAccountForNewEmail(t *testing.T) {
+	h := newOAuthHarness(t)
+	back := h.login(t, jwt.MapClaims{
+		"sub":                "new-sub",
+		"email":              "grace@example.com",
+		"email_verified":     true,
+		"preferred_username": "Grace",
+	})
+	status, body := h.exchange(t, back.Get("code"))
+	if status != http.StatusOK {
+		t.Fatalf("exchange: %d %v", status, body)
+	}
+	if got := body["user"].(map[string]interface{})["username"]; got != "grace" {
+		t.Fatalf("username = %v", got)
+	}
+	id, err := primitive.ObjectIDFromHex(body["user"].(map[string]interface{})["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := loadUser(t, h.state, id)
+	if !created.EmailVerified || len(created.Identities) != 1 || created.Identities[0].Subject != "new-sub" {
+		t.Fatalf("new user = %+v", created)
+	}
+}
@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxPageTitleLen = 100
+
+var (
+	validPageSlug   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+	errPageNotFound = errors.New("page not found")
+)
+
+type pagePayload struct {
+	Slug  string  `json:"slug"`
+	Title *string `json:"title"`
+}
+
+// pageSummary is one entry in the page list: the draft's title and whether
+// and when it was last published.
+type pageSummary struct {
+	Slug        string              `json:"slug"`
+	Title       string              `json:"title,omitempty"`
+	Default     bool                `json:"default"`
+	Version     int64               `json:"version"`
+	PublishAt   *primitive.DateTime `json:"publish_at,omitempty"`
+	PublishedAt *primitive.DateTime `json:"published_at,omitempty"`
+	UpdatedAt   *primitive.DateTime `json:"updated_at,omitempty"`
+}
+
+// pageFilter matches one of a user's pages. The default page is stored
+// without a slug.
+func pageFilter(userID primitive.ObjectID, slug string) bson.M {
+	if slug == "" {
+		return bson.M{"user": userID, "slug": bson.M{"$exists": false}}
+	}
+	return bson.M{"user": userID, "slug": slug}
+}
+
+// pageFromQuery reads the page an editor endpoint acts on from ?page=.
+// Omitting it, or passing "default", selects the default page.
+func pageFromQuery(c *fiber.Ctx) (string, error) {
+	slug := strings.TrimSpace(c.Query("page"))
+	if slug == "" || slug == models.DefaultPageSlug {
+		return "", nil
+	}
+	if !validPageSlug.MatchString(slug) {
+		return "", fmt.Errorf("invalid page")
+	}
+	return slug, nil
+}
+
+func bentoCacheKey(username, slug string) string {
+	if slug == "" {
+		return fmt.Sprintf("bento:%s", username)
+	}
+	return fmt.Sprintf("bento:%s:%s", username, slug)
+}
+
+// pageSlugs lists the slugs of every page the user has, with "" for the
+// default page.
+func pageSlugs(ctx context.Context, state *app.State, userID primitive.ObjectID) ([]string, error) {
+	slugs := []string{""}
+	seen := map[string]bool{"": true}
+	for _, pages := range []*mongo.Collection{state.Mongo.BentoConfigs(), state.Mongo.BentoDrafts()} {
+		values, err := pages.Distinct(ctx, "slug", bson.M{"user": userID})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if slug, ok := v.(string); ok && !seen[slug] {
+				seen[slug] = true
+				slugs = append(slugs, slug)
+			}
+		}
+	}
+	return slugs, nil
+}
+
+// dropPageCaches deletes the cached public copy of each page in slugs under
+// every one of usernames.
+func dropPageCaches(ctx context.Context, state *app.State, slugs []string, usernames ...string) {
+	if state.Redis == nil {
+		return
+	}
+	for _, username := range usernames {
+		for _, slug := range slugs {
+			_ = state.Redis.Del(ctx, bentoCacheKey(username, slug))
+		}
+	}
+}
+
+// ListPages returns every page the current user has, default first.
+func (bc *BentoController) ListPages(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pages, err := listPages(ctx, bc.State, userCtx.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(pages)
+}
+
+// CreatePage adds an empty named page, up to the limit for the user's role.
+// It starts as a draft and goes live on its first publish.
+func (bc *BentoController) CreatePage(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload pagePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	slug := strings.ToLower(strings.TrimSpace(payload.Slug))
+	if !validPageSlug.MatchString(slug) || slug == models.DefaultPageSlug {
+		return respondError(c, fiber.StatusBadRequest, "slug must be 1-40 lowercase letters, digits or '-'")
+	}
+	title := ""
+	if payload.Title != nil {
+		title = strings.TrimSpace(*payload.Title)
+	}
+	if len(title) > maxPageTitleLen {
+		return respondError(c, fiber.StatusBadRequest, "title is too long")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := bc.findPublisher(ctx, userCtx.ID)
+	if err != nil {
+		return respondPublisherError(c, err)
+	}
+
+	pages, err := listPages(ctx, bc.State, user.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Create failed")
+	}
+	// The default page always counts towards the limit, even before it
+	// has been saved.
+	count := len(pages)
+	if count == 0 || !pages[0].Default {
+		count++
+	}
+	if limit := bc.State.Config.PageLimit(user.Role); count >= limit {
+		return respondError(c, fiber.StatusForbidden, fmt.Sprintf("You can have at most %d pages", limit))
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	page := models.BentoConfig{
+		User:      user.ID,
+		Username:  user.Username,
+		Slug:      slug,
+		Title:     title,
+		Widgets:   []models.Widget{},
+		Layouts:   map[string]interface{}{},
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+	res, err := bc.State.Mongo.BentoDrafts().InsertOne(ctx, page)
+	if mongo.IsDuplicateKeyError(err) {
+		return respondError(c, fiber.StatusConflict, "You already have a page with that slug")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Create failed")
+	}
+	page.ID = res.InsertedID.(primitive.ObjectID)
+
+	c.Set(fiber.HeaderETag, bentoETag(page.Version))
+	return c.Status(fiber.StatusCreated).JSON(page)
+}
+
+// UpdatePage renames a page. The title is copied to the live page straight
+// away since it isn't part of the draft content.
+func (bc *BentoController) UpdatePage(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageParam(c)
+	if err != nil {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+
+	var payload pagePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if payload.Title == nil {
+		return respondError(c, fiber.StatusBadRequest, "title is required")
+	}
+	title := strings.TrimSpace(*payload.Title)
+	if len(title) > maxPageTitleLen {
+		return respondError(c, fiber.StatusBadRequest, "title is too long")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	draft, err := bc.findDraft(ctx, userCtx.ID, slug)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+
+	set := bson.M{"title": title}
+	unset := bson.M{}
+	if title == "" {
+		set, unset = bson.M{}, bson.M{"title": ""}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := pageFilter(userCtx.ID, slug)
+	if draft.ID.IsZero() {
+		// The default page has no draft until its first sync; start one
+		// from the live copy so the title has somewhere to go.
+		draft.Title = title
+		if _, err := bc.State.Mongo.BentoDrafts().InsertOne(ctx, draft); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+	}
+	for _, pages := range []*mongo.Collection{bc.State.Mongo.BentoDrafts(), bc.State.Mongo.BentoConfigs()} {
+		if _, err := pages.UpdateOne(ctx, filter, update); err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+	}
+	if bc.State.Redis != nil {
+		_ = bc.State.Redis.Del(ctx, bentoCacheKey(draft.Username, slug))
+	}
+
+	updated, err := bc.findDraft(ctx, userCtx.ID, slug)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(updated)
+}
+
+// DeletePage removes a named page, live and draft, along with its
+// revisions. The default page can't be deleted.
+func (bc *BentoController) DeletePage(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageParam(c)
+	if err != nil {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if slug == "" {
+		return respondError(c, fiber.StatusBadRequest, "The default page can't be deleted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var published models.BentoConfig
+	err = bc.State.Mongo.BentoConfigs().FindOneAndDelete(ctx, pageFilter(userCtx.ID, slug)).Decode(&published)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	res, err := bc.State.Mongo.BentoDrafts().DeleteOne(ctx, pageFilter(userCtx.ID, slug))
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	if res.DeletedCount == 0 && published.ID.IsZero() {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if _, err := bc.State.Mongo.BentoRevisions().DeleteMany(ctx, pageFilter(userCtx.ID, slug)); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+
+	if bc.State.Redis != nil && published.Username != "" {
+		_ = bc.State.Redis.Del(ctx, bentoCacheKey(published.Username, slug))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// pageParam reads the :slug route parameter, where "default" names the
+// default page.
+func pageParam(c *fiber.Ctx) (string, error) {
+	slug := c.Params("slug")
+	if slug == models.DefaultPageSlug {
+		return "", nil
+	}
+	if !validPageSlug.MatchString(slug) {
+		return "", errPageNotFound
+	}
+	return slug, nil
+}
+
+// listPages merges a user's drafts and published pages into one list. The
+// default page is listed first whenever it exists in either form.
+func listPages(ctx context.Context, state *app.State, userID primitive.ObjectID) ([]pageSummary, error) {
+	var drafts, published []models.BentoConfig
+	if err := findAll(ctx, state.Mongo.BentoDrafts(), bson.M{"user": userID}, &drafts); err != nil {
+		return nil, err
+	}
+	if err := findAll(ctx, state.Mongo.BentoConfigs(), bson.M{"user": userID}, &published); err != nil {
+		return nil, err
+	}
+
+	bySlug := map[string]*pageSummary{}
+	order := []string{}
+	add := func(page models.BentoConfig) *pageSummary {
+		summary, ok := bySlug[page.Slug]
+		if !ok {
+			summary = &pageSummary{Slug: page.Slug, Default: page.Slug == ""}
+			bySlug[page.Slug] = summary
+			order = append(order, page.Slug)
+		}
+		return summary
+	}
+	for _, page := range drafts {
+		summary := add(page)
+		summary.Title = page.Title
+		summary.Version = page.Version
+		summary.PublishAt = page.PublishAt
+		summary.UpdatedAt = page.UpdatedAt
+	}
+	for _, page := range published {
+		summary := add(page)
+		summary.PublishedAt = page.PublishedAt
+		if summary.UpdatedAt == nil {
+			summary.Title = page.Title
+			summary.UpdatedAt = page.UpdatedAt
+		}
+	}
+
+	pages := make([]pageSummary, 0, len(order))
+	if summary, ok := bySlug[""]; ok {
+		pages = append(pages, *summary)
+	}
+	for _, slug := range order {
+		if slug != "" {
+			pages = append(pages, *bySlug[slug])
+		}
+	}
+	return pages, nil
+}
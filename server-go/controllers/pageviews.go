@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pageViewDedupe is how long repeat loads of a page by the same visitor
+// count as one view.
+const pageViewDedupe = 30 * time.Minute
+
+// recordPageView logs a visitor being served user's page. ?ref= carries the
+// referrer the visitor arrived with, since the API request's own Referer is
+// the page itself. Crawlers and the owner viewing their own page are not
+// counted. The view is stored in the background so the page isn't held up;
+// request values are copied first because Fiber reuses them.
+func recordPageView(c *fiber.Ctx, state *app.State, user *models.User, slug string) {
+	device := classifyDevice(c.Get("User-Agent"))
+	if device == "bot" {
+		return
+	}
+	if viewer, ok := middleware.BearerUserID(state, c); ok && viewer == user.ID {
+		return
+	}
+
+	view := models.PageView{
+		OwnerUsername:  user.Username,
+		Page:           slug,
+		IPHash:         hashIP(c.IP()),
+		ReferrerDomain: referrerDomain(utils.CopyString(c.Query("ref"))),
+		DeviceType:     device,
+		ViewedAt:       time.Now(),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if state.Redis != nil {
+			key := fmt.Sprintf("pageview:%s:%s:%s", view.OwnerUsername, view.Page, view.IPHash)
+			if n, err := state.Redis.Incr(ctx, key, pageViewDedupe); err == nil && n > 1 {
+				return
+			}
+		}
+		_, _ = state.Mongo.PageViews().InsertOne(ctx, view)
+	}()
+}
+
+func hashIP(ip string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(ip)))
+}
+
+// viewFilter turns a click filter from buildFilters into the same filter
+// over page views. Views have no location, so location filters are
+// dropped and views count the whole page.
+func viewFilter(match bson.M) bson.M {
+	views := make(bson.M, len(match))
+	for key, value := range match {
+		switch key {
+		case "country", "region":
+			continue
+		case "clicked_at":
+			key = "viewed_at"
+		}
+		views[key] = value
+	}
+	return views
+}
+
+// countViews counts the page views matching filter, grouped by groupBy.
+func countViews(ctx context.Context, state *app.State, filter bson.M, groupBy interface{}) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   groupBy,
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := state.Mongo.PageViews().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Key   string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Key] = row.Count
+	}
+	return counts, nil
+}
+
+// clickThroughRate is clicks per view, or 0 before the page has been seen.
+func clickThroughRate(clicks, views int64) float64 {
+	if views == 0 {
+		return 0
+	}
+	return float64(clicks) / float64(views)
+}
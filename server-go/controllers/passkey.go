@@ -0,0 +1,490 @@
+package controllers
+
+import (
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	passkeyCeremonyTTL    = 5 * time.Minute
+	maxPasskeysPerUser    = 10
+	maxPasskeyNameLen     = 64
+	passkeyRegisterPrefix = "webauthn:reg:"
+	passkeyLoginPrefix    = "webauthn:login:"
+)
+
+var errCeremonyExpired = errors.New("ceremony expired")
+
+type passkeyCeremonyResponse struct {
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"`
+}
+
+type passkeyFinishPayload struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type passkeyRenamePayload struct {
+	Name string `json:"name"`
+}
+
+// passkeyUser adapts models.User to webauthn.User. The user handle is the
+// raw ObjectID, so it never reveals the email or username.
+type passkeyUser struct {
+	*models.User
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	id := u.ID
+	return id[:]
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(getString(u.FullName)); name != "" {
+		return name
+	}
+	return u.Username
+}
+
+func (u passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.Passkeys))
+	for _, pk := range u.Passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(pk.Transports))
+		for _, t := range pk.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds = append(creds, webauthn.Credential{
+			ID:              pk.ID,
+			PublicKey:       pk.PublicKey,
+			AttestationType: pk.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: pk.BackupEligible,
+				BackupState:    pk.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    pk.AAGUID,
+				SignCount: pk.SignCount,
+			},
+		})
+	}
+	return creds
+}
+
+// ListPasskeys returns the current user's passkeys.
+func (ac *AuthController) ListPasskeys(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	views := make([]models.PasskeyView, 0, len(user.Passkeys))
+	for i := range user.Passkeys {
+		views = append(views, user.Passkeys[i].View())
+	}
+	return c.JSON(views)
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create.
+func (ac *AuthController) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+	wa, err := ac.webAuthn()
+	if err != nil {
+		log.Printf("webauthn config: %v", err)
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Registration failed")
+	}
+	if len(user.Passkeys) >= maxPasskeysPerUser {
+		return respondError(c, fiber.StatusBadRequest, "Passkey limit reached")
+	}
+
+	pkUser := passkeyUser{user}
+	exclude := make([]protocol.CredentialDescriptor, 0, len(user.Passkeys))
+	for _, cred := range pkUser.WebAuthnCredentials() {
+		exclude = append(exclude, cred.Descriptor())
+	}
+	creation, session, err := wa.BeginRegistration(pkUser,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Registration failed")
+	}
+
+	ceremonyID, err := ac.storeCeremony(ctx, passkeyRegisterPrefix, session)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Registration failed")
+	}
+	return c.JSON(passkeyCeremonyResponse{CeremonyID: ceremonyID, Options: creation})
+}
+
+// FinishPasskeyRegistration verifies the attestation and stores the new
+// passkey.
+func (ac *AuthController) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload passkeyFinishPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameLen {
+		return respondError(c, fiber.StatusBadRequest, "name is too long")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+	wa, err := ac.webAuthn()
+	if err != nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := ac.takeCeremony(ctx, passkeyRegisterPrefix, payload.CeremonyID)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired ceremony")
+	}
+	if !bytes.Equal(session.UserID, userCtx.ID[:]) {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired ceremony")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(payload.Credential))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid credential")
+	}
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Registration failed")
+	}
+	cred, err := wa.CreateCredential(passkeyUser{user}, *session, parsed)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Passkey verification failed")
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	passkey := models.Passkey{
+		ID:              cred.ID,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		CreatedAt:       primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{
+			"_id": user.ID,
+			// Guards the limit against concurrent registrations.
+			fmt.Sprintf("passkeys.%d", maxPasskeysPerUser-1): bson.M{"$exists": false},
+		},
+		bson.M{"$push": bson.M{"passkeys": passkey}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return respondError(c, fiber.StatusConflict, "Passkey already registered")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Registration failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusBadRequest, "Passkey limit reached")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditPasskeyAdd, user.ID, map[string]string{"passkey": passkey.EncodedID()}))
+
+	return c.Status(fiber.StatusCreated).JSON(passkey.View())
+}
+
+// RenamePasskey changes a passkey's display name.
+func (ac *AuthController) RenamePasskey(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	credID, err := base64.RawURLEncoding.DecodeString(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	var payload passkeyRenamePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len(name) > maxPasskeyNameLen {
+		return respondError(c, fiber.StatusBadRequest, "Invalid name")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": userCtx.ID, "passkeys.id": credID},
+		bson.M{"$set": bson.M{"passkeys.$.name": name}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusNotFound, "Passkey not found")
+	}
+	return c.JSON(fiber.Map{"message": "Passkey renamed"})
+}
+
+// DeletePasskey removes one of the current user's passkeys. Password login
+// is always kept, so removing the last passkey never locks anyone out.
+func (ac *AuthController) DeletePasskey(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	credID, err := base64.RawURLEncoding.DecodeString(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": userCtx.ID, "passkeys.id": credID},
+		bson.M{"$pull": bson.M{"passkeys": bson.M{"id": credID}}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusNotFound, "Passkey not found")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditPasskeyRemove, userCtx.ID, map[string]string{"passkey": c.Params("id")}))
+	return c.JSON(fiber.Map{"message": "Passkey deleted"})
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get. No
+// username is needed: the authenticator offers the passkeys it holds.
+func (ac *AuthController) BeginPasskeyLogin(c *fiber.Ctx) error {
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+	wa, err := ac.webAuthn()
+	if err != nil {
+		log.Printf("webauthn config: %v", err)
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+
+	assertion, session, err := wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ceremonyID, err := ac.storeCeremony(ctx, passkeyLoginPrefix, session)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	return c.JSON(passkeyCeremonyResponse{CeremonyID: ceremonyID, Options: assertion})
+}
+
+// FinishPasskeyLogin verifies the assertion and signs the user in. A
+// user-verified passkey counts as a second factor on its own.
+func (ac *AuthController) FinishPasskeyLogin(c *fiber.Ctx) error {
+	var payload passkeyFinishPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if ac.State.Redis == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+	wa, err := ac.webAuthn()
+	if err != nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "Passkeys unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := ac.takeCeremony(ctx, passkeyLoginPrefix, payload.CeremonyID)
+	if err != nil {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired ceremony")
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(payload.Credential))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid credential")
+	}
+
+	var user *models.User
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != len(primitive.ObjectID{}) {
+			return nil, errors.New("unknown user handle")
+		}
+		var id primitive.ObjectID
+		copy(id[:], userHandle)
+		found, err := ac.findUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		user = found
+		return passkeyUser{found}, nil
+	}
+
+	cred, err := wa.ValidateDiscoverableLogin(lookup, *session, parsed)
+	if err != nil || user == nil {
+		if user != nil {
+			recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "passkey", models.AuditFailure, "bad_assertion"))
+		}
+		return respondError(c, fiber.StatusUnauthorized, "Passkey verification failed")
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("passkey clone warning for user %s", user.ID.Hex())
+		recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "passkey", models.AuditFailure, "clone_warning"))
+		return respondError(c, fiber.StatusUnauthorized, "Passkey verification failed")
+	}
+	if user.IsBlocked {
+		return respondError(c, fiber.StatusForbidden, "Account is blocked")
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID, "passkeys.id": cred.ID},
+		bson.M{"$set": bson.M{
+			"passkeys.$.sign_count":   cred.Authenticator.SignCount,
+			"passkeys.$.backup_state": cred.Flags.BackupState,
+			"passkeys.$.last_used_at": now,
+		}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+
+	if user.TwoFactor.Enabled && !cred.Flags.UserVerified {
+		challenge, err := signChallenge(ac.State.Config.JWTSecret, user.ID)
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Login failed")
+		}
+		return c.JSON(twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(challengeTTL.Seconds()),
+		})
+	}
+
+	resp, err := ac.issueTokens(ctx, c, user, cred.Flags.UserVerified)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "passkey", models.AuditSuccess, ""))
+	return c.JSON(resp)
+}
+
+func (ac *AuthController) webAuthn() (*webauthn.WebAuthn, error) {
+	cfg := ac.State.Config
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: "BroLink",
+		RPOrigins:     cfg.WebAuthnOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTTL, TimeoutUVD: passkeyCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTTL, TimeoutUVD: passkeyCeremonyTTL},
+		},
+	})
+}
+
+// storeCeremony keeps the WebAuthn session data in Redis and returns the id
+// the client sends back with its response.
+func (ac *AuthController) storeCeremony(ctx context.Context, prefix string, session *webauthn.SessionData) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := ac.State.Redis.SetJSON(ctx, prefix+id, session, passkeyCeremonyTTL); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// takeCeremony returns and deletes the session data, so each ceremony can be
+// completed once.
+func (ac *AuthController) takeCeremony(ctx context.Context, prefix, id string) (*webauthn.SessionData, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, errCeremonyExpired
+	}
+	var session webauthn.SessionData
+	found, err := ac.State.Redis.TakeJSON(ctx, prefix+id, &session)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errCeremonyExpired
+	}
+	return &session, nil
+}
@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	testRPID   = "app.test"
+	testOrigin = "http://app.test"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is a software passkey: one P-256 key pair, a
+// discoverable credential id, and a signature counter it reports on each
+// assertion.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+	// verified reports user verification (PIN, biometrics) in the flags.
+	verified bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credID: credID, verified: true}
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent)
+	if a.verified {
+		flags |= byte(protocol.FlagUserVerified)
+	}
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append([]byte{}, rpHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return data
+}
+
+func clientData(t *testing.T, kind, challenge string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]string{"type": kind, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// create answers navigator.credentials.create for options with a "none"
+// attestation.
+func (a *softAuthenticator) create(t *testing.T, options protocol.CredentialCreation) json.RawMessage {
+	t.Helper()
+	handle, err := b64.DecodeString(options.Response.User.ID.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = handle
+
+	coseKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := a.authData(true)
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+	data = append(data, a.credID...)
+	data = append(data, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]interface{}{
+		"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", options.Response.Challenge.String())),
+		"attestationObject": b64.EncodeToString(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+// get answers navigator.credentials.get for challenge with the current
+// signature counter.
+func (a *softAuthenticator) get(t *testing.T, challenge string) json.RawMessage {
+	t.Helper()
+	data := a.authData(false)
+	client := clientData(t, "webauthn.get", challenge)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte{}, data...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]interface{}{
+		"clientDataJSON":    b64.EncodeToString(client),
+		"authenticatorData": b64.EncodeToString(data),
+		"signature":         b64.EncodeToString(signature),
+		"userHandle":        b64.EncodeToString(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]interface{}) json.RawMessage {
+	t.Helper()
+	raw, err := json.Marshal(map[string]interface{}{
+		"id":       b64.EncodeToString(a.credID),
+		"rawId":    b64.EncodeToString(a.credID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+type passkeyHarness struct {
+	state *app.State
+	app   *fiber.App
+	ac    *AuthController
+	user  *models.User
+}
+
+// newPasskeyHarness mounts the passkey routes, with registration acting as
+// a freshly inserted user.
+func newPasskeyHarness(t *testing.T, edit func(*models.User)) *passkeyHarness {
+	t.Helper()
+	state := newTestState(t)
+	state.Config.WebAuthnRPID = testRPID
+	state.Config.WebAuthnOrigins = []string{testOrigin}
+	user := insertUser(t, state, "ada", "ada@example.com", edit)
+
+	ac := &AuthController{State: state}
+	asUser := func(c *fiber.Ctx) error {
+		c.Locals("user", &middleware.AuthUser{ID: user.ID, Role: user.Role})
+		return c.Next()
+	}
+	server := fiber.New()
+	server.Post("/auth/passkeys/register/begin", asUser, ac.BeginPasskeyRegistration)
+	server.Post("/auth/passkeys/register/finish", asUser, ac.FinishPasskeyRegistration)
+	server.Post("/auth/passkeys/login/begin", ac.BeginPasskeyLogin)
+	server.Post("/auth/passkeys/login/finish", ac.FinishPasskeyLogin)
+	return &passkeyHarness{state: state, app: server, ac: ac, user: user}
+}
+
+func (h *passkeyHarness) register(t *testing.T, auth *softAuthenticator) {
+	t.Helper()
+	resp, raw := do(t, h.app, http.MethodPost, "/auth/passkeys/register/begin", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register begin: %d %s", resp.StatusCode, raw)
+	}
+	var begin struct {
+		CeremonyID string                      `json:"ceremony_id"`
+		Options    protocol.CredentialCreation `json:"options"`
+	}
+	if err := json.Unmarshal(raw, &begin); err != nil {
+		t.Fatal(err)
+	}
+	resp, raw = do(t, h.app, http.MethodPost, "/auth/passkeys/register/finish", fiber.Map{
+		"ceremony_id": begin.CeremonyID,
+		"name":        "Laptop",
+		"credential":  auth.create(t, begin.Options),
+	}, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register finish: %d %s", resp.StatusCode, raw)
+	}
+}
+
+// beginLogin returns a login ceremony id and its challenge.
+func (h *passkeyHarness) beginLogin(t *testing.T) (string, string) {
+	t.Helper()
+	resp, raw := do(t, h.app, http.MethodPost, "/auth/passkeys/login/begin", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login begin: %d %s", resp.StatusCode, raw)
+	}
+	var begin struct {
+		CeremonyID string                       `json:"ceremony_id"`
+		Options    protocol.CredentialAssertion `json:"options"`
+	}
+	if err := json.Unmarshal(raw, &begin); err != nil {
+		t.Fatal(err)
+	}
+	return begin.CeremonyID, begin.Options.Response.Challenge.String()
+}
+
+func (h *passkeyHarness) finishLogin(t *testing.T, ceremonyID string, credential json.RawMessage) (int, map[string]interface{}) {
+	t.Helper()
+	resp, raw := do(t, h.app, http.MethodPost, "/auth/passkeys/login/finish", fiber.Map{
+		"ceremony_id": ceremonyID,
+		"credential":  credential,
+	}, nil)
+	body := map[string]interface{}{}
+	_ = json.Unmarshal(raw, &body)
+	return resp.StatusCode, body
+}
+
+// login runs a whole login ceremony with the authenticator's next count.
+func (h *passkeyHarness) login(t *testing.T, auth *softAuthenticator) (int, map[string]interface{}) {
+	t.Helper()
+	ceremonyID, challenge := h.beginLogin(t)
+	auth.signCount++
+	return h.finishLogin(t, ceremonyID, auth.get(t, challenge))
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	h := newPasskeyHarness(t, nil)
+	auth := newSoftAuthenticator(t)
+	h.register(t, auth)
+
+	stored := loadUser(t, h.state, h.user.ID)
+	if len(stored.Passkeys) != 1 || stored.Passkeys[0].Name != "Laptop" {
+		t.Fatalf("passkeys = %+v", stored.Passkeys)
+	}
+
+	status, body := h.login(t, auth)
+	if status != http.StatusOK || body["token"] == nil {
+		t.Fatalf("login: %d %v", status, body)
+	}
+	if got := body["user"].(map[string]interface{})["id"]; got != h.user.ID.Hex() {
+		t.Fatalf("signed in as %v", got)
+	}
+	if got := loadUser(t, h.state, h.user.ID).Passkeys[0].SignCount; got != auth.signCount {
+		t.Fatalf("stored sign count = %d, want %d", got, auth.signCount)
+	}
+}
+
+func TestPasskeySecondAuthenticator(t *testing.T) {
+	h := newPasskeyHarness(t, nil)
+	laptop, phone := newSoftAuthenticator(t), newSoftAuthenticator(t)
+	h.register(t, laptop)
+	h.register(t, phone)
+
+	for name, auth := range map[string]*softAuthenticator{"laptop": laptop, "phone": phone} {
+		if status, body := h.login(t, auth); status != http.StatusOK {
+			t.Fatalf("%s login: %d %v", name, status, body)
+		}
+	}
+}
+
+func TestPasskeyLoginRejectsReplayedCeremony(t *testing.T) {
+	h := newPasskeyHarness(t, nil)
+	auth := newSoftAuthenticator(t)
+	h.register(t, auth)
+
+	ceremonyID, challenge := h.beginLogin(t)
+	auth.signCount++
+	assertion := auth.get(t, challenge)
+	if status, body := h.finishLogin(t, ceremonyID, assertion); status != http.StatusOK {
+		t.Fatalf("first login: %d %v", status, body)
+	}
+	if status, _ := h.finishLogin(t, ceremonyID, assertion); status != http.StatusUnauthorized {
+		t.Fatalf("replayed ceremony: %d", status)
+	}
+
+	// The same signed assertion doesn't answer a fresh challenge either.
+	fresh, _ := h.beginLogin(t)
+	if status, _ := h.finishLogin(t, fresh, assertion); status != http.StatusUnauthorized {
+		t.Fatalf("replayed assertion: %d", status)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	h := newPasskeyHarness(t, nil)
+	auth := newSoftAuthenticator(t)
+	h.register(t, auth)
+
+	auth.signCount = 9
+	ceremonyID, challenge := h.beginLogin(t)
+	if status, body := h.finishLogin(t, ceremonyID, auth.get(t, challenge)); status != http.StatusOK {
+		t.Fatalf("login: %d %v", status, body)
+	}
+
+	// A clone still counting from an older state.
+	auth.signCount = 4
+	ceremonyID, challenge = h.beginLogin(t)
+	if status, body := h.finishLogin(t, ceremonyID, auth.get(t, challenge)); status != http.StatusUnauthorized {
+		t.Fatalf("regressed count: %d %v", status, body)
+	}
+}
+
+func TestPasskeyLoginRequiresUserVerification(t *testing.T) {
+	h := newPasskeyHarness(t, func(u *models.User) {
+		u.TwoFactor = models.TwoFactor{Enabled: true, Secret: "JBSWY3DPEHPK3PXP"}
+	})
+	auth := newSoftAuthenticator(t)
+	h.register(t, auth)
+
+	auth.verified = false
+	if status, body := h.login(t, auth); status != http.StatusUnauthorized || body["token"] != nil {
+		t.Fatalf("unverified login: %d %v", status, body)
+	}
+}
+
+// TestPasskeyUnverifiedLoginNeedsSecondFactor covers the fallback for a
+// ceremony that doesn't demand user verification: a 2FA account must still
+// be asked for its code.
+func TestPasskeyUnverifiedLoginNeedsSecondFactor(t *testing.T) {
+	h := newPasskeyHarness(t, func(u *models.User) {
+		u.TwoFactor = models.TwoFactor{Enabled: true, Secret: "JBSWY3DPEHPK3PXP"}
+	})
+	auth := newSoftAuthenticator(t)
+	h.register(t, auth)
+
+	wa, err := h.ac.webAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ceremonyID, err := h.ac.storeCeremony(ctx, passkeyLoginPrefix, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth.verified = false
+	auth.signCount++
+	status, body := h.finishLogin(t, ceremonyID, auth.get(t, assertion.Response.Challenge.String()))
+	if status != http.StatusOK || body["two_factor_required"] != true || body["token"] != nil {
+		t.Fatalf("unverified 2FA login: %d %v", status, body)
+	}
+}
+
+func TestPasskeyRegistrationCeremonyIsPerUser(t *testing.T) {
+	h := newPasskeyHarness(t, nil)
+	resp, raw := do(t, h.app, http.MethodPost, "/auth/passkeys/register/begin", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register begin: %d %s", resp.StatusCode, raw)
+	}
+	var begin struct {
+		CeremonyID string                      `json:"ceremony_id"`
+		Options    protocol.CredentialCreation `json:"options"`
+	}
+	if err := json.Unmarshal(raw, &begin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else finishing this user's ceremony.
+	other := primitive.NewObjectID()
+	server := fiber.New()
+	server.Post("/finish", func(c *fiber.Ctx) error {
+		c.Locals("user", &middleware.AuthUser{ID: other, Role: models.RoleUser})
+		return c.Next()
+	}, h.ac.FinishPasskeyRegistration)
+	resp, _ = do(t, server, http.MethodPost, "/finish", fiber.Map{
+		"ceremony_id": begin.CeremonyID,
+		"credential":  newSoftAuthenticator(t).create(t, begin.Options),
+	}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("cross-user finish: %d", resp.StatusCode)
+	}
+}
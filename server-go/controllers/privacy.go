@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const accountPurgeInterval = time.Hour
+
+var errAccountDeleted = errors.New("account deleted")
+
+type accountExport struct {
+	ExportedAt time.Time            `json:"exported_at"`
+	User       models.PublicUser    `json:"user"`
+	Pages      []models.BentoConfig `json:"pages"`
+	Uploads    []models.Upload      `json:"uploads"`
+	Clicks     []models.ClickEvent  `json:"clicks"`
+	PageViews  []models.PageView    `json:"page_views"`
+	Sessions   []models.SessionView `json:"sessions"`
+	APIKeys    []models.APIKey      `json:"api_keys"`
+}
+
+// ExportMe returns everything stored about the current user as a single
+// JSON download.
+func (ac *AuthController) ExportMe(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+
+	export := accountExport{
+		ExportedAt: time.Now().UTC(),
+		User:       user.Public(),
+		Pages:      []models.BentoConfig{},
+		Uploads:    []models.Upload{},
+		Clicks:     []models.ClickEvent{},
+		PageViews:  []models.PageView{},
+		APIKeys:    []models.APIKey{},
+	}
+
+	mongoDB := ac.State.Mongo
+	byUser := bson.M{"user": user.ID}
+	if err := findAll(ctx, mongoDB.BentoConfigs(), byUser, &export.Pages); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+	if err := findAll(ctx, mongoDB.Uploads(), byUser, &export.Uploads); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+	if err := findAll(ctx, mongoDB.Clicks(), bson.M{"owner_username": user.Username}, &export.Clicks); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+	if err := findAll(ctx, mongoDB.PageViews(), bson.M{"owner_username": user.Username}, &export.PageViews); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+	if err := findAll(ctx, mongoDB.APIKeys(), byUser, &export.APIKeys); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+	export.Sessions, err = listSessions(ctx, ac.State, user.ID, userCtx.SessionID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Export failed")
+	}
+
+	filename := fmt.Sprintf("brolink-%s-%s.json", user.Username, export.ExportedAt.Format("20060102"))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.JSON(export)
+}
+
+// DeleteMe schedules the current account for deletion. The page goes dark
+// and every session and API key is revoked straight away; the data itself
+// is purged once ACCOUNT_DELETION_GRACE has passed unless the user signs in
+// again before then.
+func (ac *AuthController) DeleteMe(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload twoFactorCodePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if ok, _ := ac.State.Passwords.Verify(user.Password, payload.Password); !ok {
+		return respondError(c, fiber.StatusBadRequest, "Invalid credentials")
+	}
+	if user.TwoFactor.Enabled {
+		if ok, err := ac.verifySecondFactor(ctx, user, payload.Code, payload.RecoveryCode); err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+		} else if !ok {
+			return respondError(c, fiber.StatusBadRequest, "Invalid code")
+		}
+	}
+
+	deleteAfter := primitive.NewDateTimeFromTime(time.Now().Add(ac.State.Config.AccountDeleteGrace))
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"delete_after": deleteAfter}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+
+	if err := revokeSessions(ctx, ac.State, bson.M{"user": user.ID}); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	if _, err := ac.State.Mongo.APIKeys().DeleteMany(ctx, bson.M{"user": user.ID}); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Delete failed")
+	}
+	if slugs, err := pageSlugs(ctx, ac.State, user.ID); err == nil {
+		dropPageCaches(ctx, ac.State, slugs, user.Username)
+	} else {
+		log.Printf("delete account %s: list pages: %v", user.Username, err)
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditAccountDelete, user.ID, map[string]string{
+		"delete_after": deleteAfter.Time().UTC().Format(time.RFC3339),
+	}))
+
+	sendMail(ac.State, services.MailMessage{
+		To:      user.Email,
+		Subject: "Your BroLink account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour BroLink account and all of its data will be deleted on %s.\n"+
+			"Log in again before then if you change your mind.\n",
+			user.Username, deleteAfter.Time().UTC().Format("2 January 2006")),
+	})
+
+	return c.JSON(fiber.Map{
+		"message":      "Account scheduled for deletion",
+		"delete_after": deleteAfter,
+	})
+}
+
+// RunAccountPurger deletes accounts whose grace window has ended, once at
+// startup and then every hour until ctx is cancelled.
+func RunAccountPurger(ctx context.Context, state *app.State, uploadsDir string) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeDeletedAccounts(ctx, state, uploadsDir)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeDeletedAccounts(ctx context.Context, state *app.State, uploadsDir string) {
+	findCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	due := bson.M{"delete_after": bson.M{"$lte": primitive.NewDateTimeFromTime(time.Now())}}
+	var users []models.User
+	if err := findAll(findCtx, state.Mongo.Users(), due, &users); err != nil {
+		log.Printf("account purge: %v", err)
+		return
+	}
+	for i := range users {
+		if err := purgeAccount(ctx, state, uploadsDir, &users[i]); err != nil {
+			log.Printf("account purge %s: %v", users[i].ID.Hex(), err)
+		}
+	}
+}
+
+// purgeAccount removes the user and everything that belongs to them. The
+// user document goes first, guarded on delete_after, so a login that
+// cancels the deletion at the last moment wins.
+func purgeAccount(ctx context.Context, state *app.State, uploadsDir string, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	mongoDB := state.Mongo
+	res, err := mongoDB.Users().DeleteOne(ctx, bson.M{
+		"_id":          user.ID,
+		"delete_after": bson.M{"$lte": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	if err != nil || res.DeletedCount == 0 {
+		return err
+	}
+
+	var uploads []models.Upload
+	if err := findAll(ctx, mongoDB.Uploads(), bson.M{"user": user.ID}, &uploads); err != nil {
+		return err
+	}
+	deleteUploadedFiles(ctx, state, uploadsDir, uploads)
+
+	var redirects []models.UsernameRedirect
+	if err := findAll(ctx, mongoDB.UsernameRedirects(), bson.M{"user": user.ID}, &redirects); err != nil {
+		return err
+	}
+
+	slugs, err := pageSlugs(ctx, state, user.ID)
+	if err != nil {
+		return err
+	}
+
+	byUser := bson.M{"user": user.ID}
+	for _, coll := range []*mongo.Collection{
+		mongoDB.BentoConfigs(),
+		mongoDB.BentoDrafts(),
+		mongoDB.BentoRevisions(),
+		mongoDB.Uploads(),
+		mongoDB.Sessions(),
+		mongoDB.ActionTokens(),
+		mongoDB.APIKeys(),
+		mongoDB.UsernameRedirects(),
+	} {
+		if _, err := coll.DeleteMany(ctx, byUser); err != nil {
+			return err
+		}
+	}
+	for _, events := range []*mongo.Collection{mongoDB.Clicks(), mongoDB.PageViews(), mongoDB.AnalyticsRollups()} {
+		if _, err := events.DeleteMany(ctx, bson.M{"owner_username": user.Username}); err != nil {
+			return err
+		}
+	}
+	if _, err := mongoDB.LockoutEvents().DeleteMany(ctx, bson.M{"email": user.Email}); err != nil {
+		return err
+	}
+
+	usernames := []string{user.Username}
+	for _, redirect := range redirects {
+		usernames = append(usernames, redirect.Username)
+	}
+	dropPageCaches(ctx, state, slugs, usernames...)
+	return nil
+}
+
+// deleteUploadedFiles removes stored media from disk or Cloudinary. Failures
+// are logged rather than returned so one missing file doesn't block the rest
+// of the purge.
+func deleteUploadedFiles(ctx context.Context, state *app.State, uploadsDir string, uploads []models.Upload) {
+	var cloud *services.CloudinaryClient
+	if state.Config.CloudinaryConfigured() {
+		cloud, _ = services.NewCloudinaryClient(state.Config)
+	}
+
+	for _, upload := range uploads {
+		switch upload.Storage {
+		case models.StorageCloudinary:
+			if cloud == nil {
+				log.Printf("account purge: cannot delete %s, cloudinary not configured", upload.Filename)
+				continue
+			}
+			if err := cloud.DeleteImage(ctx, upload.Filename); err != nil {
+				log.Printf("account purge: delete %s: %v", upload.Filename, err)
+			}
+		case models.StorageLocal:
+			path := filepath.Join(uploadsDir, filepath.Base(upload.Filename))
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("account purge: delete %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// findAll decodes every document matching filter into out.
+func findAll(ctx context.Context, coll *mongo.Collection, filter bson.M, out interface{}) error {
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, out)
+}
@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxFullNameLen  = 100
+	maxAvatarURLLen = 2048
+	emailChangeTTL  = 24 * time.Hour
+)
+
+var (
+	validUsername = regexp.MustCompile(`^[A-Za-z0-9_-]{3,30}$`)
+	validEmail    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// reservedUsernames can't be taken as handles because they collide with
+// fixed paths: the editor endpoints under /bento/ and the client's own
+// top-level pages.
+var reservedUsernames = map[string]bool{
+	"draft":       true,
+	"pages":       true,
+	"preview":     true,
+	"publish":     true,
+	"revisions":   true,
+	"sync":        true,
+	"admin":       true,
+	"admin-login": true,
+	"signup":      true,
+}
+
+func usernameReserved(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+type profilePayload struct {
+	FullName  *string `json:"full_name"`
+	AvatarURL *string `json:"avatar_url"`
+	Username  *string `json:"username"`
+}
+
+type emailChangePayload struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// UpdateMe edits the current user's profile. A username change is carried
+// over to their bento pages and click history in one transaction, and the
+// old handle keeps redirecting for USERNAME_REDIRECT_TTL.
+func (ac *AuthController) UpdateMe(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload profilePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	set := bson.M{}
+	if payload.FullName != nil {
+		name := strings.TrimSpace(*payload.FullName)
+		if len(name) > maxFullNameLen {
+			return respondError(c, fiber.StatusBadRequest, "full_name is too long")
+		}
+		set["full_name"] = name
+	}
+	if payload.AvatarURL != nil {
+		avatar := strings.TrimSpace(*payload.AvatarURL)
+		if avatar != "" {
+			parsed, err := url.Parse(avatar)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return respondError(c, fiber.StatusBadRequest, "avatar_url must be an http(s) URL")
+			}
+		}
+		if len(avatar) > maxAvatarURLLen {
+			return respondError(c, fiber.StatusBadRequest, "avatar_url is too long")
+		}
+		set["avatar_url"] = avatar
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	newUsername := user.Username
+	if payload.Username != nil {
+		newUsername = strings.TrimSpace(*payload.Username)
+		if !validUsername.MatchString(newUsername) {
+			return respondError(c, fiber.StatusBadRequest, "username must be 3-30 letters, digits, '_' or '-'")
+		}
+		if usernameReserved(newUsername) && newUsername != user.Username {
+			return respondError(c, fiber.StatusConflict, "Username is taken")
+		}
+	}
+
+	if len(set) == 0 && newUsername == user.Username {
+		return c.JSON(user.Public())
+	}
+	set["updatedAt"] = now
+
+	if newUsername == user.Username {
+		_, err = ac.State.Mongo.Users().UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set})
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+	} else {
+		reserved, err := ac.State.Mongo.UsernameRedirects().CountDocuments(ctx, bson.M{
+			"username":   newUsername,
+			"user":       bson.M{"$ne": user.ID},
+			"expires_at": bson.M{"$gt": now},
+		})
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+		if reserved > 0 {
+			return respondError(c, fiber.StatusConflict, "Username is taken")
+		}
+
+		set["username"] = newUsername
+		err = ac.renameUser(ctx, user, newUsername, set)
+		if mongo.IsDuplicateKeyError(err) {
+			return respondError(c, fiber.StatusConflict, "Username is taken")
+		}
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Update failed")
+		}
+
+		if slugs, err := pageSlugs(ctx, ac.State, user.ID); err == nil {
+			dropPageCaches(ctx, ac.State, slugs, user.Username, newUsername)
+		} else {
+			log.Printf("rename %s: list pages: %v", user.Username, err)
+		}
+		recordAudit(ac.State, c, selfAudit(models.AuditUsernameChange, user.ID, map[string]string{
+			"from": user.Username,
+			"to":   newUsername,
+		}))
+	}
+
+	updated, err := ac.findUser(ctx, user.ID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(updated.Public())
+}
+
+// renameUser applies set to the user and moves every document keyed by the
+// old username over to the new one.
+func (ac *AuthController) renameUser(ctx context.Context, user *models.User, newUsername string, set bson.M) error {
+	mongoDB := ac.State.Mongo
+	ttl := ac.State.Config.UsernameRedirectTTL
+	now := time.Now()
+
+	return mongoDB.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := mongoDB.Users().UpdateOne(sessCtx, bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+			return err
+		}
+		for _, pages := range []*mongo.Collection{mongoDB.BentoConfigs(), mongoDB.BentoDrafts()} {
+			if _, err := pages.UpdateMany(sessCtx,
+				bson.M{"user": user.ID},
+				bson.M{"$set": bson.M{"username": newUsername}},
+			); err != nil {
+				return err
+			}
+		}
+		for _, events := range []*mongo.Collection{mongoDB.Clicks(), mongoDB.PageViews(), mongoDB.AnalyticsRollups()} {
+			if _, err := events.UpdateMany(sessCtx,
+				bson.M{"owner_username": user.Username},
+				bson.M{"$set": bson.M{"owner_username": newUsername}},
+			); err != nil {
+				return err
+			}
+		}
+
+		// Taking back one of your own old handles drops its redirect.
+		if _, err := mongoDB.UsernameRedirects().DeleteMany(sessCtx, bson.M{"username": newUsername}); err != nil {
+			return err
+		}
+		if ttl <= 0 {
+			return nil
+		}
+		_, err := mongoDB.UsernameRedirects().UpdateOne(sessCtx,
+			bson.M{"username": user.Username},
+			bson.M{
+				"$set": bson.M{
+					"user":       user.ID,
+					"expires_at": primitive.NewDateTimeFromTime(now.Add(ttl)),
+				},
+				"$setOnInsert": bson.M{"createdAt": primitive.NewDateTimeFromTime(now)},
+			},
+			options.Update().SetUpsert(true),
+		)
+		return err
+	})
+}
+
+// ChangeEmail starts an email change. The new address only replaces the
+// old one once the link sent to it is confirmed.
+func (ac *AuthController) ChangeEmail(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload emailChangePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	email := strings.TrimSpace(payload.Email)
+	if !validEmail.MatchString(email) {
+		return respondError(c, fiber.StatusBadRequest, "A valid email is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if ok, _ := ac.State.Passwords.Verify(user.Password, payload.Password); !ok {
+		return respondError(c, fiber.StatusBadRequest, "Invalid credentials")
+	}
+	if email == user.Email {
+		return respondError(c, fiber.StatusBadRequest, "That is already your email")
+	}
+
+	taken, err := ac.State.Mongo.Users().CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+	if taken > 0 {
+		return respondError(c, fiber.StatusConflict, "Email already in use")
+	}
+
+	target := *user
+	target.Email = email
+	token, err := issueActionToken(ctx, ac.State, &target, models.TokenEmailChange, emailChangeTTL)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Request failed")
+	}
+
+	link := fmt.Sprintf("%s/confirm-email?token=%s", ac.State.Config.ClientURL, url.QueryEscape(token))
+	sendMail(ac.State, services.MailMessage{
+		To:      email,
+		Subject: "Confirm your new BroLink email",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link within 24 hours to make this your BroLink email:\n\n%s\n",
+			user.Username, link),
+	})
+	sendMail(ac.State, services.MailMessage{
+		To:      user.Email,
+		Subject: "Your BroLink email is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change your BroLink email to %s.\n"+
+			"If this wasn't you, reset your password right away.\n", user.Username, email),
+	})
+
+	return c.JSON(fiber.Map{"message": "Check your new inbox to confirm the change"})
+}
+
+// ConfirmEmailChange applies a pending email change.
+func (ac *AuthController) ConfirmEmailChange(c *fiber.Ctx) error {
+	var payload verifyEmailPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Token) == "" {
+		return respondError(c, fiber.StatusBadRequest, "token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := consumeActionToken(ctx, ac.State, payload.Token, models.TokenEmailChange)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+
+	var previous models.User
+	err = ac.State.Mongo.Users().FindOneAndUpdate(ctx,
+		bson.M{"_id": token.User},
+		bson.M{"$set": bson.M{
+			"email":          token.Email,
+			"email_verified": true,
+			"updatedAt":      primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	).Decode(&previous)
+	if mongo.IsDuplicateKeyError(err) {
+		return respondError(c, fiber.StatusConflict, "Email already in use")
+	}
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+
+	recordAudit(ac.State, c, selfAudit(models.AuditEmailChange, previous.ID, nil))
+
+	sendMail(ac.State, services.MailMessage{
+		To:      previous.Email,
+		Subject: "Your BroLink email was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nYour BroLink email is now %s. This address will no longer receive account mail.\n",
+			previous.Username, token.Email),
+	})
+
+	return c.JSON(fiber.Map{"message": "Email updated"})
+}
@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	previewAudience          = "bento-preview"
+	scheduledPublishInterval = time.Minute
+)
+
+var errNoDraft = errors.New("no draft")
+
+type publishPayload struct {
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+type previewClaims struct {
+	Page string `json:"page,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GetDraft returns the current user's draft of the page named by ?page=.
+// Until the default page's first sync there is no draft yet, so its
+// published copy is returned as version 0 to start editing from.
+func (bc *BentoController) GetDraft(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	draft, err := bc.findDraft(ctx, userCtx.ID, slug)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	c.Set(fiber.HeaderETag, bentoETag(draft.Version))
+	return c.JSON(draft)
+}
+
+// PublishBento copies the current user's draft of the page named by
+// ?page= to the live page, or with
+// publish_at in the future schedules that for later. A scheduled publish
+// puts live whatever the draft holds when it fires. If-Match, when sent,
+// must match the draft version.
+func (bc *BentoController) PublishBento(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	var payload publishPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+		}
+	}
+	expected, force, err := expectedVersion(c, nil)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if force {
+		expected = -1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := bc.findPublisher(ctx, userCtx.ID); err != nil {
+		return respondPublisherError(c, err)
+	}
+
+	if payload.PublishAt != nil && payload.PublishAt.After(time.Now()) {
+		filter := pageFilter(userCtx.ID, slug)
+		if expected >= 0 {
+			filter["version"] = expected
+		}
+		var draft models.BentoConfig
+		err := bc.State.Mongo.BentoDrafts().FindOneAndUpdate(ctx, filter,
+			bson.M{"$set": bson.M{"publish_at": primitive.NewDateTimeFromTime(*payload.PublishAt)}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&draft)
+		if err == mongo.ErrNoDocuments {
+			if expected >= 0 {
+				return bc.respondConflict(ctx, c, userCtx.ID, slug)
+			}
+			return respondError(c, fiber.StatusNotFound, "Nothing to publish")
+		}
+		if err != nil {
+			return respondError(c, fiber.StatusInternalServerError, "Publish failed")
+		}
+		return c.JSON(draft)
+	}
+
+	published, err := publishDraft(ctx, bc.State, userCtx.ID, slug, expected)
+	if err == errNoDraft {
+		return respondError(c, fiber.StatusNotFound, "Nothing to publish")
+	}
+	if err == errBentoConflict {
+		return bc.respondConflict(ctx, c, userCtx.ID, slug)
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Publish failed")
+	}
+	return c.JSON(published)
+}
+
+// CancelScheduledPublish drops a pending scheduled publish of the page
+// named by ?page=.
+func (bc *BentoController) CancelScheduledPublish(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = bc.State.Mongo.BentoDrafts().UpdateOne(ctx,
+		pageFilter(userCtx.ID, slug),
+		bson.M{"$unset": bson.M{"publish_at": "", "publish_leased_until": ""}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Update failed")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// CreatePreview issues a link that shows the current user's draft of the
+// page named by ?page= to anyone holding it until BENTO_PREVIEW_TTL runs
+// out.
+func (bc *BentoController) CreatePreview(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"_id": userCtx.ID}).Decode(&user); err != nil {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+
+	expiresAt := time.Now().Add(bc.State.Config.BentoPreviewTTL)
+	token, err := signPreview(bc.State.Config.JWTSecret, user.ID, slug, expiresAt)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Preview failed")
+	}
+
+	path := url.PathEscape(user.Username)
+	if slug != "" {
+		path += "/" + slug
+	}
+	return c.JSON(fiber.Map{
+		"token":      token,
+		"url":        fmt.Sprintf("%s/%s?preview=%s", bc.State.Config.ClientURL, path, url.QueryEscape(token)),
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// GetPreview returns the draft a preview token was issued for.
+func (bc *BentoController) GetPreview(c *fiber.Ctx) error {
+	userID, slug, err := parsePreview(bc.State.Config.JWTSecret, c.Query("token"))
+	if err != nil {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired preview link")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := bc.State.Mongo.Users().FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if user.IsBlocked || user.DeleteAfter != nil {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+
+	draft, err := bc.findDraft(ctx, userID, slug)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	return c.JSON(draft)
+}
+
+// findDraft returns the draft of one of the user's pages. The default page
+// falls back to its published copy (as version 0), or an empty page, when
+// no draft has been saved yet; a missing named page is ErrNoDocuments.
+func (bc *BentoController) findDraft(ctx context.Context, userID primitive.ObjectID, slug string) (*models.BentoConfig, error) {
+	var draft models.BentoConfig
+	err := bc.State.Mongo.BentoDrafts().FindOne(ctx, pageFilter(userID, slug)).Decode(&draft)
+	if err == nil {
+		return &draft, nil
+	}
+	if err != mongo.ErrNoDocuments || slug != "" {
+		return nil, err
+	}
+
+	err = bc.State.Mongo.BentoConfigs().FindOne(ctx, pageFilter(userID, slug)).Decode(&draft)
+	if err == mongo.ErrNoDocuments {
+		draft = models.BentoConfig{
+			User:    userID,
+			Widgets: []models.Widget{},
+			Layouts: map[string]interface{}{},
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	draft.ID = primitive.NilObjectID
+	draft.Version = 0
+	draft.PublishedAt = nil
+	return &draft, nil
+}
+
+// publishDraft copies the draft of one of the user's pages to its live
+// copy and drops the cached public copy. With expected >= 0 the draft must
+// be at that version.
+func publishDraft(ctx context.Context, state *app.State, userID primitive.ObjectID, slug string, expected int64) (*models.BentoConfig, error) {
+	var draft models.BentoConfig
+	err := state.Mongo.BentoDrafts().FindOne(ctx, pageFilter(userID, slug)).Decode(&draft)
+	if err == mongo.ErrNoDocuments {
+		return nil, errNoDraft
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expected >= 0 && draft.Version != expected {
+		return nil, errBentoConflict
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	set := bson.M{
+		"username":    draft.Username,
+		"widgets":     draft.Widgets,
+		"layouts":     draft.Layouts,
+		"version":     draft.Version,
+		"publishedAt": now,
+		"updatedAt":   now,
+	}
+	if draft.Title != "" {
+		set["title"] = draft.Title
+	}
+	var published models.BentoConfig
+	err = state.Mongo.BentoConfigs().FindOneAndUpdate(ctx,
+		pageFilter(userID, slug),
+		bson.M{
+			"$set":         set,
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&published)
+	if err != nil {
+		return nil, err
+	}
+
+	// A manual publish supersedes any pending scheduled one.
+	_, _ = state.Mongo.BentoDrafts().UpdateOne(ctx,
+		bson.M{"_id": draft.ID},
+		bson.M{"$unset": bson.M{"publish_at": "", "publish_leased_until": ""}},
+	)
+	if state.Redis != nil {
+		_ = state.Redis.Del(ctx, bentoCacheKey(published.Username, slug))
+	}
+	return &published, nil
+}
+
+// RunScheduledPublisher publishes drafts whose publish_at has passed, once
+// at startup and then every minute until ctx is cancelled.
+func RunScheduledPublisher(ctx context.Context, state *app.State) {
+	ticker := time.NewTicker(scheduledPublishInterval)
+	defer ticker.Stop()
+	for {
+		publishDueDrafts(ctx, state)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishDueDrafts publishes every due draft it can. Each draft is leased
+// for one interval first so two servers never publish the same schedule
+// twice; publish_at is only cleared by a successful publish, so a draft
+// that fails, or whose owner may not publish right now, is retried once its
+// lease runs out. It gives up until the next tick when a draft can't be
+// claimed, so an unreachable database or a shutdown doesn't spin.
+func publishDueDrafts(ctx context.Context, state *app.State) {
+	for ctx.Err() == nil {
+		runCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		now := time.Now()
+		var draft models.BentoConfig
+		err := state.Mongo.BentoDrafts().FindOneAndUpdate(runCtx,
+			bson.M{
+				"publish_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)},
+				"$or": bson.A{
+					bson.M{"publish_leased_until": bson.M{"$exists": false}},
+					bson.M{"publish_leased_until": bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+				},
+			},
+			bson.M{"$set": bson.M{"publish_leased_until": primitive.NewDateTimeFromTime(now.Add(scheduledPublishInterval))}},
+		).Decode(&draft)
+		if err != nil {
+			cancel()
+			if err != mongo.ErrNoDocuments {
+				log.Printf("scheduled publish: %v", err)
+			}
+			return
+		}
+		err = publishScheduled(runCtx, state, &draft)
+		cancel()
+		if err != nil {
+			log.Printf("scheduled publish %s: %v", draft.ID.Hex(), err)
+		}
+	}
+}
+
+// publishScheduled publishes a leased draft unless its owner is blocked,
+// on the way out, or still has to verify their email.
+func publishScheduled(ctx context.Context, state *app.State, draft *models.BentoConfig) error {
+	var user models.User
+	if err := state.Mongo.Users().FindOne(ctx, bson.M{"_id": draft.User}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("owner not found")
+		}
+		return err
+	}
+	// The schedule stays in place and is looked at again next lease, in
+	// case the owner is unblocked, cancels deletion or verifies.
+	if user.IsBlocked || user.DeleteAfter != nil || (state.Config.RequireVerifiedMail && !user.EmailVerified) {
+		return nil
+	}
+	_, err := publishDraft(ctx, state, draft.User, draft.Slug, -1)
+	return err
+}
+
+func signPreview(secret string, userID primitive.ObjectID, slug string, expiresAt time.Time) (string, error) {
+	claims := previewClaims{
+		Page: slug,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.Hex(),
+			Audience:  jwt.ClaimStrings{previewAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+func parsePreview(secret, tokenString string) (primitive.ObjectID, string, error) {
+	claims := &previewClaims{}
+	token, err := jwt.ParseWithClaims(strings.TrimSpace(tokenString), claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secret), nil
+	}, jwt.WithAudience(previewAudience))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, "", jwt.ErrTokenInvalidClaims
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	return userID, claims.Page, err
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"brolink-server/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errLinkNotFound = errors.New("link not found")
+
+// RedirectLink sends a visitor to a link widget's URL and records the
+// click on the way, so tracking doesn't depend on the page's JavaScript.
+// The URL always comes from the owner's published page; ?page= selects a
+// named page and ?ref= carries the page's own referrer, falling back to the
+// Referer header. A click that fails to save still redirects.
+func (ac *AnalyticsController) RedirectLink(c *fiber.Ctx) error {
+	username := c.Params("username")
+	widgetID := c.Params("widgetId")
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusNotFound, "Link not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, widget, err := ac.findLinkWidget(ctx, username, slug, widgetID)
+	if err == errLinkNotFound {
+		return respondError(c, fiber.StatusNotFound, "Link not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	event := models.ClickEvent{
+		WidgetID:       widget.ID,
+		OwnerUsername:  user.Username,
+		Page:           slug,
+		URL:            widget.URL,
+		CustomTitle:    widget.CustomTitle,
+		CustomImage:    widget.CustomImage,
+		ReferrerDomain: referrerDomain(c.Query("ref", c.Get(fiber.HeaderReferer))),
+	}
+	if err := ac.saveClick(c, &event); err != nil {
+		log.Printf("redirect: record click for %s/%s: %v", user.Username, widget.ID, err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Redirect(widget.URL, fiber.StatusFound)
+}
+
+// findLinkWidget looks up a link widget on the page visitors currently see
+// at /bento/:username (or its named page slug). It returns errLinkNotFound
+// unless the owner is active and the widget is a visible link with a URL.
+func (ac *AnalyticsController) findLinkWidget(ctx context.Context, username, slug, widgetID string) (*models.User, *models.Widget, error) {
+	var user models.User
+	err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, errLinkNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.IsBlocked || user.DeleteAfter != nil {
+		return nil, nil, errLinkNotFound
+	}
+
+	page, err := publicPage(ctx, ac.State, &user, slug)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, errLinkNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	widget := findWidget(page.Widgets, widgetID)
+	if widget == nil || widget.Kind() != models.WidgetLink || widget.URL == "" {
+		return nil, nil, errLinkNotFound
+	}
+	return &user, widget, nil
+}
+
+func findWidget(widgets []models.Widget, id string) *models.Widget {
+	for i := range widgets {
+		if widgets[i].ID == id {
+			return &widgets[i]
+		}
+	}
+	return nil
+}
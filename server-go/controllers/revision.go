@@ -0,0 +1,452 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultRevisionLimit = 20
+	maxRevisionLimit     = 100
+)
+
+type widgetChange struct {
+	ID     string        `json:"id"`
+	Fields []string      `json:"fields"`
+	Before models.Widget `json:"before"`
+	After  models.Widget `json:"after"`
+}
+
+type layoutPosition struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// layoutChange is a widget that was placed, moved, resized or removed at
+// one breakpoint. Before is nil when it was placed and After when removed.
+type layoutChange struct {
+	Breakpoint string          `json:"breakpoint"`
+	WidgetID   string          `json:"widget_id"`
+	Before     *layoutPosition `json:"before,omitempty"`
+	After      *layoutPosition `json:"after,omitempty"`
+}
+
+// bentoDiff describes how to get from one revision to another. From is
+// nil when comparing against an empty page.
+type bentoDiff struct {
+	From    *primitive.ObjectID `json:"from"`
+	To      primitive.ObjectID  `json:"to"`
+	Added   []models.Widget     `json:"added"`
+	Removed []models.Widget     `json:"removed"`
+	Changed []widgetChange      `json:"changed"`
+	Layout  []layoutChange      `json:"layout"`
+}
+
+// recordRevision snapshots page as written by author and prunes the
+// page's history down to BENTO_REVISION_LIMIT. The editor syncs seconds
+// after every change, so a write by the same author soon after the latest
+// revision was started folds into it instead; restores always start a new
+// one. Failures are logged but never fail the write that triggered them.
+func recordRevision(ctx context.Context, state *app.State, page *models.BentoConfig, author primitive.ObjectID, restoredFrom *primitive.ObjectID) {
+	revisions := state.Mongo.BentoRevisions()
+	widgets := page.Widgets
+	if widgets == nil {
+		widgets = []models.Widget{}
+	}
+
+	if restoredFrom == nil {
+		filter := pageFilter(page.User, page.Slug)
+		filter["author"] = author
+		filter["restored_from"] = bson.M{"$exists": false}
+		filter["createdAt"] = bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now().Add(-state.Config.BentoRevisionWindow))}
+		// Only the newest revision may absorb the write, or history
+		// would be rewritten out of order.
+		var latest struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := revisions.FindOne(ctx, pageFilter(page.User, page.Slug), options.FindOne().
+			SetSort(bson.M{"_id": -1}).
+			SetProjection(bson.M{"_id": 1})).Decode(&latest)
+		if err == nil {
+			filter["_id"] = latest.ID
+			res, err := revisions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+				"version": page.Version,
+				"widgets": widgets,
+				"layouts": page.Layouts,
+			}})
+			if err == nil && res.MatchedCount > 0 {
+				return
+			}
+			if err != nil {
+				log.Printf("bento revision %s: %v", page.User.Hex(), err)
+			}
+		}
+	}
+
+	revision := models.BentoRevision{
+		Page:         page.ID,
+		Slug:         page.Slug,
+		User:         page.User,
+		Author:       author,
+		Version:      page.Version,
+		Widgets:      widgets,
+		Layouts:      page.Layouts,
+		RestoredFrom: restoredFrom,
+		CreatedAt:    primitive.NewDateTimeFromTime(time.Now()),
+	}
+	if _, err := revisions.InsertOne(ctx, revision); err != nil {
+		log.Printf("bento revision %s: %v", page.User.Hex(), err)
+		return
+	}
+
+	var oldest struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().
+		SetSort(bson.M{"_id": -1}).
+		SetSkip(int64(state.Config.BentoRevisionLimit - 1)).
+		SetProjection(bson.M{"_id": 1})
+	err := revisions.FindOne(ctx, pageFilter(page.User, page.Slug), opts).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+	if err == nil {
+		filter := pageFilter(page.User, page.Slug)
+		filter["_id"] = bson.M{"$lt": oldest.ID}
+		_, err = revisions.DeleteMany(ctx, filter)
+	}
+	if err != nil {
+		log.Printf("bento revision prune %s: %v", page.User.Hex(), err)
+	}
+}
+
+// ListRevisions returns the history of the current user's page named by
+// ?page=, newest first, without widget contents. ?before= takes a revision
+// id to page back from.
+func (bc *BentoController) ListRevisions(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	slug, err := pageFromQuery(c)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	match := pageFilter(userCtx.ID, slug)
+	if raw := strings.TrimSpace(c.Query("before")); raw != "" {
+		before, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "Invalid before")
+		}
+		match["_id"] = bson.M{"$lt": before}
+	}
+	limit := c.QueryInt("limit", defaultRevisionLimit)
+	if limit <= 0 || limit > maxRevisionLimit {
+		limit = defaultRevisionLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{
+			"author":        1,
+			"version":       1,
+			"restored_from": 1,
+			"createdAt":     1,
+			"widget_count":  bson.M{"$size": bson.M{"$ifNull": bson.A{"$widgets", bson.A{}}}},
+		}}},
+	}
+	cursor, err := bc.State.Mongo.BentoRevisions().Aggregate(ctx, pipeline)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	defer cursor.Close(ctx)
+
+	revisions := make([]models.RevisionSummary, 0)
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(revisions)
+}
+
+// GetRevision returns one of the current user's revisions in full.
+func (bc *BentoController) GetRevision(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	revisionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	revision, err := bc.findRevision(ctx, userCtx.ID, bson.M{"_id": revisionID})
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(revision)
+}
+
+// DiffRevision compares a revision with ?against=, or with the revision
+// of the same page before it when against is omitted.
+func (bc *BentoController) DiffRevision(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	revisionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+	var againstID *primitive.ObjectID
+	if raw := strings.TrimSpace(c.Query("against")); raw != "" {
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "Invalid against")
+		}
+		againstID = &id
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	to, err := bc.findRevision(ctx, userCtx.ID, bson.M{"_id": revisionID})
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	filter := pageFilter(userCtx.ID, to.Slug)
+	filter["_id"] = bson.M{"$lt": revisionID}
+	if againstID != nil {
+		filter = bson.M{"_id": *againstID}
+	}
+	from, err := bc.findRevision(ctx, userCtx.ID, filter)
+	if err == mongo.ErrNoDocuments {
+		if againstID != nil {
+			return respondError(c, fiber.StatusNotFound, "Revision not found")
+		}
+		from = &models.BentoRevision{}
+	} else if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	diff := diffRevisions(from, to)
+	return c.JSON(diff)
+}
+
+// RestoreRevision writes a revision's widgets and layouts back to the
+// draft of the page it came from as a new version. If-Match is honoured when sent; without it the
+// restore overwrites whatever is live.
+func (bc *BentoController) RestoreRevision(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+	revisionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+	expected, force, err := expectedVersion(c, nil)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if expected < 0 {
+		force = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	revision, err := bc.findRevision(ctx, userCtx.ID, bson.M{"_id": revisionID})
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+
+	user, err := bc.findPublisher(ctx, userCtx.ID)
+	if err != nil {
+		return respondPublisherError(c, err)
+	}
+
+	updated, err := bc.writeBento(ctx, user, userCtx.ID, revision.Slug, revision.Widgets, revision.Layouts, expected, force, &revision.ID)
+	if err == errPageNotFound {
+		return respondError(c, fiber.StatusNotFound, "Page not found")
+	}
+	if err == errBentoConflict {
+		return bc.respondConflict(ctx, c, userCtx.ID, revision.Slug)
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Restore failed")
+	}
+
+	c.Set(fiber.HeaderETag, bentoETag(updated.Version))
+	return c.JSON(updated)
+}
+
+// findRevision returns the newest of the user's revisions matching filter.
+func (bc *BentoController) findRevision(ctx context.Context, userID primitive.ObjectID, filter bson.M) (*models.BentoRevision, error) {
+	filter["user"] = userID
+	var revision models.BentoRevision
+	opts := options.FindOne().SetSort(bson.M{"_id": -1})
+	if err := bc.State.Mongo.BentoRevisions().FindOne(ctx, filter, opts).Decode(&revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func diffRevisions(from, to *models.BentoRevision) bentoDiff {
+	diff := bentoDiff{
+		To:      to.ID,
+		Added:   []models.Widget{},
+		Removed: []models.Widget{},
+		Changed: []widgetChange{},
+		Layout:  []layoutChange{},
+	}
+	if !from.ID.IsZero() {
+		diff.From = &from.ID
+	}
+
+	before := make(map[string]models.Widget, len(from.Widgets))
+	for _, w := range from.Widgets {
+		before[w.ID] = w
+	}
+	after := make(map[string]bool, len(to.Widgets))
+	for _, w := range to.Widgets {
+		after[w.ID] = true
+		old, ok := before[w.ID]
+		if !ok {
+			diff.Added = append(diff.Added, w)
+			continue
+		}
+		if fields := changedFields(old, w); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, widgetChange{ID: w.ID, Fields: fields, Before: old, After: w})
+		}
+	}
+	for _, w := range from.Widgets {
+		if !after[w.ID] {
+			diff.Removed = append(diff.Removed, w)
+		}
+	}
+
+	oldLayout, newLayout := layoutPositions(from.Layouts), layoutPositions(to.Layouts)
+	breakpoints := make([]string, 0, len(models.LayoutBreakpoints))
+	for breakpoint := range models.LayoutBreakpoints {
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	sort.Strings(breakpoints)
+	for _, breakpoint := range breakpoints {
+		oldItems, newItems := oldLayout[breakpoint], newLayout[breakpoint]
+		ids := make([]string, 0, len(oldItems)+len(newItems))
+		for id := range oldItems {
+			ids = append(ids, id)
+		}
+		for id := range newItems {
+			if _, ok := oldItems[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			oldPos, hadOld := oldItems[id]
+			newPos, hasNew := newItems[id]
+			if hadOld && hasNew && oldPos == newPos {
+				continue
+			}
+			change := layoutChange{Breakpoint: breakpoint, WidgetID: id}
+			if hadOld {
+				change.Before = &oldPos
+			}
+			if hasNew {
+				change.After = &newPos
+			}
+			diff.Layout = append(diff.Layout, change)
+		}
+	}
+	return diff
+}
+
+// changedFields lists the JSON fields that differ between two versions of
+// a widget.
+func changedFields(old, new models.Widget) []string {
+	oldFields, newFields := widgetFields(old), widgetFields(new)
+	fields := []string{}
+	for name, value := range newFields {
+		if !bytes.Equal(oldFields[name], value) {
+			fields = append(fields, name)
+		}
+	}
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func widgetFields(w models.Widget) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	raw, err := json.Marshal(w)
+	if err == nil {
+		_ = json.Unmarshal(raw, &fields)
+	}
+	return fields
+}
+
+// layoutPositions indexes a stored layout by breakpoint and widget id.
+// Layouts round-trip through JSON because their stored shape depends on
+// how the driver decoded them.
+func layoutPositions(layouts map[string]interface{}) map[string]map[string]layoutPosition {
+	var decoded map[string][]struct {
+		I string  `json:"i"`
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+		W float64 `json:"w"`
+		H float64 `json:"h"`
+	}
+	if raw, err := json.Marshal(layouts); err == nil {
+		_ = json.Unmarshal(raw, &decoded)
+	}
+
+	positions := make(map[string]map[string]layoutPosition, len(decoded))
+	for breakpoint, items := range decoded {
+		byID := make(map[string]layoutPosition, len(items))
+		for _, item := range items {
+			byID[item.I] = layoutPosition{X: int(item.X), Y: int(item.Y), W: int(item.W), H: int(item.H)}
+		}
+		positions[breakpoint] = byID
+	}
+	return positions
+}
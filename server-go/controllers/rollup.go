@@ -0,0 +1,584 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/models"
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	rollupInterval = 15 * time.Minute
+	// rollupGrace leaves a day open a little past midnight so background
+	// geo lookups on its last clicks land before it is rolled up.
+	rollupGrace   = 5 * time.Minute
+	rollupStateID = "daily"
+	rollupDay     = "2006-01-02"
+)
+
+type rollupState struct {
+	ID            string    `bson:"_id"`
+	RolledThrough time.Time `bson:"rolled_through"`
+}
+
+// rollupKey identifies the widget (or, with an empty Widget, the page) an
+// aggregated row belongs to.
+type rollupKey struct {
+	Owner  string `bson:"owner"`
+	Page   string `bson:"page"`
+	Widget string `bson:"widget"`
+}
+
+// RunAnalyticsRollup rolls up each closed day of clicks and page views into
+// per-widget daily counters, once at startup and then every rollupInterval
+// until ctx is cancelled.
+func RunAnalyticsRollup(ctx context.Context, state *app.State) {
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+	for {
+		rollUpClosedDays(ctx, state)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollUpClosedDays rolls up every day between the last one done and
+// yesterday, oldest first, and stops at the first failure so no day is
+// skipped.
+func rollUpClosedDays(ctx context.Context, state *app.State) {
+	runCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	next, err := rolledThrough(runCtx, state)
+	if err == nil && next.IsZero() {
+		next, err = firstEventDay(runCtx, state)
+	}
+	cancel()
+	if err != nil {
+		log.Printf("analytics rollup: %v", err)
+		return
+	}
+	if next.IsZero() {
+		return
+	}
+
+	open := startOfDay(time.Now().Add(-rollupGrace))
+	for day := next; day.Before(open); day = day.AddDate(0, 0, 1) {
+		dayCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		err := rollUpDay(dayCtx, state, day)
+		if err == nil {
+			_, err = state.Mongo.RollupState().UpdateOne(dayCtx,
+				bson.M{"_id": rollupStateID},
+				bson.M{"$set": bson.M{"rolled_through": day.AddDate(0, 0, 1)}},
+				options.Update().SetUpsert(true),
+			)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("analytics rollup %s: %v", day.Format(rollupDay), err)
+			return
+		}
+	}
+}
+
+// rolledThrough returns the end of the last rolled-up day; every day before
+// it is in the rollups. It is zero until the first day has been rolled up.
+func rolledThrough(ctx context.Context, state *app.State) (time.Time, error) {
+	var st rollupState
+	err := state.Mongo.RollupState().FindOne(ctx, bson.M{"_id": rollupStateID}).Decode(&st)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.RolledThrough.UTC(), nil
+}
+
+// firstEventDay returns the day of the oldest click or page view, or zero
+// when there are none yet.
+func firstEventDay(ctx context.Context, state *app.State) (time.Time, error) {
+	var first time.Time
+	for _, src := range []struct {
+		coll  *mongo.Collection
+		field string
+	}{
+		{state.Mongo.Clicks(), "clicked_at"},
+		{state.Mongo.PageViews(), "viewed_at"},
+	} {
+		var doc bson.M
+		opts := options.FindOne().SetSort(bson.M{src.field: 1}).SetProjection(bson.M{src.field: 1})
+		err := src.coll.FindOne(ctx, bson.M{}, opts).Decode(&doc)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if at, ok := doc[src.field].(primitive.DateTime); ok {
+			if t := at.Time(); first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+	}
+	if first.IsZero() {
+		return first, nil
+	}
+	return startOfDay(first), nil
+}
+
+// rollUpDay recomputes every rollup for the day starting at day from the
+// raw events. Rollups are replaced wholesale, so running a day twice is
+// harmless. A rename that lands mid-run moves the raw events but not the
+// rows this run is about to write, so those rows are dropped afterwards
+// and the day is run once more under the new name.
+func rollUpDay(ctx context.Context, state *app.State, day time.Time) error {
+	owners, err := rollUpDayOnce(ctx, state, day)
+	if err != nil {
+		return err
+	}
+	orphaned, err := dropOrphanedRollups(ctx, state, day, owners)
+	if err != nil || !orphaned {
+		return err
+	}
+	owners, err = rollUpDayOnce(ctx, state, day)
+	if err != nil {
+		return err
+	}
+	_, err = dropOrphanedRollups(ctx, state, day, owners)
+	return err
+}
+
+// rollUpDayOnce writes the day's rollups and returns the owners it wrote
+// them under.
+func rollUpDayOnce(ctx context.Context, state *app.State, day time.Time) ([]string, error) {
+	inDay := bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}
+	clicks := bson.M{"clicked_at": inDay}
+	rollups := map[rollupKey]*models.AnalyticsRollup{}
+	get := func(key rollupKey) *models.AnalyticsRollup {
+		r, ok := rollups[key]
+		if !ok {
+			r = &models.AnalyticsRollup{
+				OwnerUsername: key.Owner,
+				Page:          key.Page,
+				WidgetID:      key.Widget,
+				Day:           day.Format(rollupDay),
+			}
+			rollups[key] = r
+		}
+		return r
+	}
+
+	var totals []struct {
+		Key         rollupKey `bson:"_id"`
+		URL         string    `bson:"url"`
+		CustomTitle string    `bson:"custom_title"`
+		CustomImage string    `bson:"custom_image"`
+		Clicks      int64     `bson:"clicks"`
+		Unique      int64     `bson:"unique"`
+	}
+	err := aggregateAll(ctx, state.Mongo.Clicks(), mongo.Pipeline{
+		{{Key: "$match", Value: clicks}},
+		{{Key: "$sort", Value: bson.M{"clicked_at": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          widgetGroupKey(nil),
+			"url":          bson.M{"$last": "$url"},
+			"custom_title": bson.M{"$last": "$custom_title"},
+			"custom_image": bson.M{"$last": "$custom_image"},
+			"clicks":       bson.M{"$sum": 1},
+			"ips":          bson.M{"$addToSet": "$ip_hash"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"unique": bson.M{"$size": "$ips"}}}},
+		{{Key: "$project", Value: bson.M{"ips": 0}}},
+	}, &totals)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range totals {
+		r := get(t.Key)
+		r.URL, r.CustomTitle, r.CustomImage = t.URL, t.CustomTitle, t.CustomImage
+		r.Clicks, r.Unique = t.Clicks, t.Unique
+	}
+
+	for _, dim := range []struct {
+		field string
+		add   func(*models.AnalyticsRollup, models.RollupCount)
+	}{
+		{"$device_type", func(r *models.AnalyticsRollup, c models.RollupCount) { r.Devices = append(r.Devices, c) }},
+		{"$referrer_domain", func(r *models.AnalyticsRollup, c models.RollupCount) { r.Referrers = append(r.Referrers, c) }},
+	} {
+		var rows []struct {
+			Key struct {
+				Widget rollupKey `bson:",inline"`
+				Value  string    `bson:"value"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+		err := aggregateAll(ctx, state.Mongo.Clicks(), mongo.Pipeline{
+			{{Key: "$match", Value: clicks}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   widgetGroupKey(bson.M{"value": dim.field}),
+				"count": bson.M{"$sum": 1},
+			}}},
+		}, &rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			dim.add(get(row.Key.Widget), models.RollupCount{Key: row.Key.Value, Count: row.Count})
+		}
+	}
+
+	var places []struct {
+		Key struct {
+			Widget  rollupKey `bson:",inline"`
+			Country string    `bson:"country"`
+			Region  string    `bson:"region"`
+			City    string    `bson:"city"`
+		} `bson:"_id"`
+		CountryCode string `bson:"country_code"`
+		Count       int64  `bson:"count"`
+	}
+	err = aggregateAll(ctx, state.Mongo.Clicks(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"clicked_at": inDay,
+			"country":    bson.M{"$type": "string", "$ne": ""},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": widgetGroupKey(bson.M{
+				"country": "$country",
+				"region":  "$region",
+				"city":    "$city",
+			}),
+			"country_code": bson.M{"$first": "$country_code"},
+			"count":        bson.M{"$sum": 1},
+		}}},
+	}, &places)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range places {
+		r := get(p.Key.Widget)
+		r.Geo = append(r.Geo, models.GeoCount{
+			Country:     p.Key.Country,
+			CountryCode: p.CountryCode,
+			Region:      p.Key.Region,
+			City:        p.Key.City,
+			Count:       p.Count,
+		})
+	}
+
+	var views []struct {
+		Key   rollupKey `bson:"_id"`
+		Count int64     `bson:"count"`
+	}
+	err = aggregateAll(ctx, state.Mongo.PageViews(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"viewed_at": inDay}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"owner":  "$owner_username",
+				"page":   bson.M{"$ifNull": bson.A{"$page", ""}},
+				"widget": "",
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}, &views)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range views {
+		get(v.Key).Views = v.Count
+	}
+
+	if len(rollups) == 0 {
+		return nil, nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(rollups))
+	seen := map[string]bool{}
+	var owners []string
+	for _, r := range rollups {
+		if !seen[r.OwnerUsername] {
+			seen[r.OwnerUsername] = true
+			owners = append(owners, r.OwnerUsername)
+		}
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{
+				"owner_username": r.OwnerUsername,
+				"day":            r.Day,
+				"page":           r.Page,
+				"widget_id":      r.WidgetID,
+			}).
+			SetReplacement(r).
+			SetUpsert(true))
+	}
+	_, err = state.Mongo.AnalyticsRollups().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return owners, err
+}
+
+// dropOrphanedRollups deletes the day's rollups for any of owners that no
+// longer names a user, and reports whether there were any.
+func dropOrphanedRollups(ctx context.Context, state *app.State, day time.Time, owners []string) (bool, error) {
+	if len(owners) == 0 {
+		return false, nil
+	}
+	current, err := state.Mongo.Users().Distinct(ctx, "username", bson.M{"username": bson.M{"$in": owners}})
+	if err != nil {
+		return false, err
+	}
+	live := map[string]bool{}
+	for _, name := range current {
+		if s, ok := name.(string); ok {
+			live[s] = true
+		}
+	}
+	var gone []string
+	for _, owner := range owners {
+		if !live[owner] {
+			gone = append(gone, owner)
+		}
+	}
+	if len(gone) == 0 {
+		return false, nil
+	}
+	_, err = state.Mongo.AnalyticsRollups().DeleteMany(ctx, bson.M{
+		"day":            day.Format(rollupDay),
+		"owner_username": bson.M{"$in": gone},
+	})
+	return err == nil, err
+}
+
+// widgetGroupKey groups raw clicks by owner, page and widget, plus any
+// extra fields. Clicks on the default page have no page field.
+func widgetGroupKey(extra bson.M) bson.M {
+	key := bson.M{
+		"owner":  "$owner_username",
+		"page":   bson.M{"$ifNull": bson.A{"$page", ""}},
+		"widget": "$widget_id",
+	}
+	for k, v := range extra {
+		key[k] = v
+	}
+	return key
+}
+
+func aggregateAll(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, out interface{}) error {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, out)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// rollupWindow is the run of whole days, [From, To), that an analytics
+// query reads from rollups; the rest of its range still comes from raw
+// events. A zero From reaches back to the first rollup.
+type rollupWindow struct {
+	From, To time.Time
+	ok       bool
+}
+
+// rollupWindowFor picks the rolled-up days that lie wholly inside the date
+// range of the click filter match. Location filters need per-event detail,
+// so those queries read raw events only.
+func rollupWindowFor(ctx context.Context, state *app.State, match bson.M) rollupWindow {
+	if _, ok := match["country"]; ok {
+		return rollupWindow{}
+	}
+	if _, ok := match["region"]; ok {
+		return rollupWindow{}
+	}
+	through, err := rolledThrough(ctx, state)
+	if err != nil || through.IsZero() {
+		return rollupWindow{}
+	}
+
+	start, end := dateRange(match, "clicked_at")
+	w := rollupWindow{To: through}
+	if !start.IsZero() {
+		w.From = startOfDay(start)
+		if w.From.Before(start) {
+			w.From = w.From.AddDate(0, 0, 1)
+		}
+	}
+	if !end.IsZero() && startOfDay(end).Before(w.To) {
+		w.To = startOfDay(end)
+	}
+	if !w.From.Before(w.To) {
+		return rollupWindow{}
+	}
+	w.ok = true
+	return w
+}
+
+// rawFilter narrows match to the parts of its date range on field that the
+// window doesn't cover.
+func (w rollupWindow) rawFilter(match bson.M, field string) bson.M {
+	if !w.ok {
+		return match
+	}
+	start, end := dateRange(match, field)
+	raw := make(bson.M, len(match)+1)
+	for key, value := range match {
+		if key != field {
+			raw[key] = value
+		}
+	}
+
+	after := bson.M{"$gte": w.To}
+	if !end.IsZero() {
+		after["$lte"] = end
+	}
+	ranges := bson.A{bson.M{field: after}}
+	if !start.IsZero() && start.Before(w.From) {
+		ranges = append(ranges, bson.M{field: bson.M{"$gte": start, "$lt": w.From}})
+	}
+	raw["$or"] = ranges
+	return raw
+}
+
+// rollupFilter matches the window's rollups for the owner and page that
+// match selects.
+func (w rollupWindow) rollupFilter(match bson.M) bson.M {
+	day := bson.M{"$lt": w.To.Format(rollupDay)}
+	if !w.From.IsZero() {
+		day["$gte"] = w.From.Format(rollupDay)
+	}
+	filter := bson.M{"owner_username": match["owner_username"], "day": day}
+	switch page := match["page"].(type) {
+	case string:
+		filter["page"] = page
+	case bson.M:
+		// Raw events on the default page have no page field; their
+		// rollups have an empty one.
+		filter["page"] = ""
+	}
+	return filter
+}
+
+func dateRange(match bson.M, field string) (time.Time, time.Time) {
+	q, _ := match[field].(bson.M)
+	start, _ := q["$gte"].(time.Time)
+	end, _ := q["$lte"].(time.Time)
+	return start, end
+}
+
+// rollupWidgetStats sums rollups per widget, keeping the most recent URL,
+// title and image.
+func rollupWidgetStats(ctx context.Context, state *app.State, filter bson.M) ([]models.WidgetClickStat, error) {
+	filter["widget_id"] = bson.M{"$ne": ""}
+	stats := make([]models.WidgetClickStat, 0)
+	err := aggregateAll(ctx, state.Mongo.AnalyticsRollups(), mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.M{"day": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          "$widget_id",
+			"page":         bson.M{"$last": "$page"},
+			"url":          bson.M{"$last": "$url"},
+			"custom_title": bson.M{"$last": "$custom_title"},
+			"custom_image": bson.M{"$last": "$custom_image"},
+			"total":        bson.M{"$sum": "$clicks"},
+			"unique":       bson.M{"$sum": "$unique"},
+		}}},
+	}, &stats)
+	return stats, err
+}
+
+// rollupTotals sums a rollup counter, "clicks" or "views", grouped by
+// groupBy ("$page" or "$day").
+func rollupTotals(ctx context.Context, state *app.State, filter bson.M, counter, groupBy string) (map[string]int64, error) {
+	var rows []struct {
+		Key   string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	err := aggregateAll(ctx, state.Mongo.AnalyticsRollups(), mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   groupBy,
+			"count": bson.M{"$sum": "$" + counter},
+		}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		totals[row.Key] += row.Count
+	}
+	return totals, nil
+}
+
+// rollupBreakdown sums one of the rollup breakdowns, "devices" or
+// "referrers", by key.
+func rollupBreakdown(ctx context.Context, state *app.State, filter bson.M, field string) (map[string]int64, error) {
+	var rows []struct {
+		Key   string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	err := aggregateAll(ctx, state.Mongo.AnalyticsRollups(), mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$unwind", Value: "$" + field}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$" + field + ".key",
+			"count": bson.M{"$sum": "$" + field + ".count"},
+		}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Key] += row.Count
+	}
+	return counts, nil
+}
+
+// rollupGeo sums the rollups' location counts in the shape GetGeo returns.
+func rollupGeo(ctx context.Context, state *app.State, filter bson.M) ([]models.GeoStat, error) {
+	stats := make([]models.GeoStat, 0)
+	err := aggregateAll(ctx, state.Mongo.AnalyticsRollups(), mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$unwind", Value: "$geo"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"city":    "$geo.city",
+				"region":  "$geo.region",
+				"country": "$geo.country",
+			},
+			"country_code": bson.M{"$first": "$geo.country_code"},
+			"count":        bson.M{"$sum": "$geo.count"},
+		}}},
+	}, &stats)
+	return stats, err
+}
+
+// topCounts returns the keys of counts from most to least common, at most
+// limit of them when limit is positive.
+func topCounts(counts map[string]int64, limit int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
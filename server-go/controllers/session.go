@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"brolink-server/app"
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type refreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access token and rotates the
+// refresh token. Presenting an already-rotated token revokes the session,
+// since it means the token was copied.
+func (ac *AuthController) Refresh(c *fiber.Ctx) error {
+	var payload refreshPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	presented := strings.TrimSpace(payload.RefreshToken)
+	if presented == "" {
+		return respondError(c, fiber.StatusBadRequest, "refresh_token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sessions := ac.State.Mongo.Sessions()
+	hash := hashToken(presented)
+	now := time.Now()
+
+	var session models.Session
+	err := sessions.FindOne(ctx, bson.M{"refresh_hash": hash}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		var reused models.Session
+		if sessions.FindOne(ctx, bson.M{"prev_refresh_hash": hash}).Decode(&reused) == nil {
+			_ = revokeSessions(ctx, ac.State, bson.M{"_id": reused.ID})
+		}
+		return respondError(c, fiber.StatusUnauthorized, "Invalid refresh token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Refresh failed")
+	}
+	if session.RevokedAt != nil || session.ExpiresAt.Time().Before(now) {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid refresh token")
+	}
+
+	var user models.User
+	err = ac.State.Mongo.Users().FindOne(ctx, bson.M{"_id": session.User}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		_ = revokeSessions(ctx, ac.State, bson.M{"_id": session.ID})
+		return respondError(c, fiber.StatusUnauthorized, "Invalid refresh token")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Refresh failed")
+	}
+	if user.IsBlocked {
+		_ = revokeSessions(ctx, ac.State, bson.M{"user": user.ID})
+		return respondError(c, fiber.StatusForbidden, "Account is blocked")
+	}
+
+	next, err := randomToken()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Refresh failed")
+	}
+	res, err := sessions.UpdateOne(ctx,
+		bson.M{"_id": session.ID, "refresh_hash": hash},
+		bson.M{"$set": bson.M{
+			"refresh_hash":      hashToken(next),
+			"prev_refresh_hash": hash,
+			"last_used_at":      primitive.NewDateTimeFromTime(now),
+		}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Refresh failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid refresh token")
+	}
+
+	resp, err := ac.tokenResponse(&user, &session, next)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Refresh failed")
+	}
+	return c.JSON(resp)
+}
+
+// Logout revokes the session behind the current access token, or every
+// session of the user when called with ?all=true.
+func (ac *AuthController) Logout(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userCtx.SessionID, "user": userCtx.ID}
+	if c.QueryBool("all") {
+		filter = bson.M{"user": userCtx.ID}
+	}
+	if err := revokeSessions(ctx, ac.State, filter); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Logout failed")
+	}
+	details := map[string]string{"scope": "session"}
+	if c.QueryBool("all") {
+		details["scope"] = "all"
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditLogout, userCtx.ID, details))
+	return c.JSON(fiber.Map{"message": "Logged out"})
+}
+
+// GetSessions lists the current user's active sessions.
+func (ac *AuthController) GetSessions(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	views, err := listSessions(ctx, ac.State, userCtx.ID, userCtx.SessionID)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	return c.JSON(views)
+}
+
+// RevokeSession terminates one of the current user's sessions.
+func (ac *AuthController) RevokeSession(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid id")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := ac.State.Mongo.Sessions().UpdateOne(ctx,
+		activeSessionFilter(bson.M{"_id": sessionID, "user": userCtx.ID}),
+		bson.M{"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Revoke failed")
+	}
+	if res.MatchedCount == 0 {
+		return respondError(c, fiber.StatusNotFound, "Session not found")
+	}
+	return c.JSON(fiber.Map{"message": "Session revoked"})
+}
+
+func listSessions(ctx context.Context, state *app.State, userID, currentID primitive.ObjectID) ([]models.SessionView, error) {
+	opts := options.Find().SetSort(bson.M{"last_used_at": -1})
+	cursor, err := state.Mongo.Sessions().Find(ctx, activeSessionFilter(bson.M{"user": userID}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	views := make([]models.SessionView, 0, len(sessions))
+	for i := range sessions {
+		views = append(views, sessions[i].View(currentID))
+	}
+	return views, nil
+}
+
+// revokeSessions marks every active session matching filter as revoked.
+func revokeSessions(ctx context.Context, state *app.State, filter bson.M) error {
+	_, err := state.Mongo.Sessions().UpdateMany(ctx,
+		activeSessionFilter(filter),
+		bson.M{"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())}},
+	)
+	return err
+}
+
+func activeSessionFilter(filter bson.M) bson.M {
+	active := bson.M{
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	for k, v := range filter {
+		active[k] = v
+	}
+	return active
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+}
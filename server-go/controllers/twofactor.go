@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"brolink-server/middleware"
+	"brolink-server/models"
+	"brolink-server/services"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	challengeTTL      = 5 * time.Minute
+	challengeAudience = "2fa-challenge"
+	totpIssuer        = "BroLink"
+	recoveryCodeCount = 10
+)
+
+type twoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+type twoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_code"`
+}
+
+type twoFactorCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+}
+
+type twoFactorLoginPayload struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// SetupTwoFactor starts TOTP enrollment by generating a pending secret. It
+// only takes effect once EnableTwoFactor confirms a code from it.
+func (ac *AuthController) SetupTwoFactor(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if user.TwoFactor.Enabled {
+		return respondError(c, fiber.StatusBadRequest, "Two-factor authentication is already enabled")
+	}
+
+	secret, err := services.GenerateTOTPSecret()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Setup failed")
+	}
+	uri := services.TOTPURI(totpIssuer, user.Email, secret)
+	qr, err := services.TOTPQRCode(uri)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Setup failed")
+	}
+
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"two_factor.pending_secret": secret}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Setup failed")
+	}
+
+	return c.JSON(twoFactorSetupResponse{Secret: secret, OTPAuthURI: uri, QRCode: qr})
+}
+
+// EnableTwoFactor confirms the pending secret with a code and returns a
+// fresh set of recovery codes. They are only ever shown here.
+func (ac *AuthController) EnableTwoFactor(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload twoFactorCodePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if user.TwoFactor.Enabled {
+		return respondError(c, fiber.StatusBadRequest, "Two-factor authentication is already enabled")
+	}
+	if user.TwoFactor.PendingSecret == "" {
+		return respondError(c, fiber.StatusBadRequest, "Start two-factor setup first")
+	}
+
+	counter, valid := services.ValidateTOTP(user.TwoFactor.PendingSecret, payload.Code, time.Now())
+	if !valid {
+		return respondError(c, fiber.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Enable failed")
+	}
+
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"two_factor": models.TwoFactor{
+				Enabled:       true,
+				Secret:        user.TwoFactor.PendingSecret,
+				RecoveryCodes: hashes,
+				LastCounter:   counter,
+			},
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Enable failed")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditTwoFactorEnable, user.ID, nil))
+
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a current
+// code or recovery code.
+func (ac *AuthController) DisableTwoFactor(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload twoFactorCodePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if !user.TwoFactor.Enabled {
+		return respondError(c, fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+	if ok, _ := ac.State.Passwords.Verify(user.Password, payload.Password); !ok {
+		return respondError(c, fiber.StatusBadRequest, "Invalid credentials")
+	}
+	if ok, err := ac.verifySecondFactor(ctx, user, payload.Code, payload.RecoveryCode); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Disable failed")
+	} else if !ok {
+		return respondError(c, fiber.StatusBadRequest, "Invalid code")
+	}
+
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"two_factor": ""},
+			"$set":   bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+		},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Disable failed")
+	}
+	recordAudit(ac.State, c, selfAudit(models.AuditTwoFactorDisable, user.ID, nil))
+
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current TOTP code.
+func (ac *AuthController) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userCtx, ok := middleware.CurrentUser(c)
+	if !ok {
+		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	var payload twoFactorCodePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userCtx.ID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Fetch failed")
+	}
+	if !user.TwoFactor.Enabled {
+		return respondError(c, fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+	if ok, err := ac.verifySecondFactor(ctx, user, payload.Code, ""); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Regenerate failed")
+	} else if !ok {
+		return respondError(c, fiber.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Regenerate failed")
+	}
+	_, err = ac.State.Mongo.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"two_factor.recovery_codes": hashes}},
+	)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Regenerate failed")
+	}
+
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// LoginTwoFactor completes a login that Login answered with a challenge.
+func (ac *AuthController) LoginTwoFactor(c *fiber.Ctx) error {
+	var payload twoFactorLoginPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Code) == "" && strings.TrimSpace(payload.RecoveryCode) == "" {
+		return respondError(c, fiber.StatusBadRequest, "code is required")
+	}
+
+	userID, err := parseChallenge(ac.State.Config.JWTSecret, payload.ChallengeToken)
+	if err != nil {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired challenge")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := ac.findUser(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired challenge")
+	}
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	if user.IsBlocked {
+		return respondError(c, fiber.StatusForbidden, "Account is blocked")
+	}
+	if !user.TwoFactor.Enabled {
+		return respondError(c, fiber.StatusUnauthorized, "Invalid or expired challenge")
+	}
+
+	guard := newLoginGuard(ac.State, c, user.Email)
+	if wait := guard.retryAfter(ctx); wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+	if ok, err := ac.verifySecondFactor(ctx, user, payload.Code, payload.RecoveryCode); err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	} else if !ok {
+		recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "2fa", models.AuditFailure, "bad_code"))
+		if locked := guard.recordFailure(ctx, &user.ID); locked > 0 {
+			return tooManyAttempts(c, locked)
+		}
+		return respondError(c, fiber.StatusBadRequest, "Invalid code")
+	}
+	guard.recordSuccess(ctx)
+
+	resp, err := ac.issueTokens(ctx, c, user, true)
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "Login failed")
+	}
+	recordAudit(ac.State, c, loginAudit(&user.ID, user.Email, "2fa", models.AuditSuccess, ""))
+	return c.JSON(resp)
+}
+
+// verifySecondFactor accepts either a TOTP code, which must be newer than
+// the last one used, or an unused recovery code, which is consumed.
+func (ac *AuthController) verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	users := ac.State.Mongo.Users()
+
+	if code = strings.TrimSpace(code); code != "" {
+		counter, valid := services.ValidateTOTP(user.TwoFactor.Secret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		res, err := users.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "$or": []bson.M{
+				{"two_factor.last_counter": bson.M{"$exists": false}},
+				{"two_factor.last_counter": bson.M{"$lt": counter}},
+			}},
+			bson.M{"$set": bson.M{"two_factor.last_counter": counter}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return res.ModifiedCount > 0, nil
+	}
+
+	normalized := normalizeRecoveryCode(recoveryCode)
+	if normalized == "" {
+		return false, nil
+	}
+	hash := hashToken(normalized)
+	res, err := users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "two_factor.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"two_factor.recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+func (ac *AuthController) findUser(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	if err := ac.State.Mongo.Users().FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// generateRecoveryCodes returns the codes to show the user and the hashes
+// to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+type challengeClaims struct {
+	ID string `json:"id"`
+	jwt.RegisteredClaims
+}
+
+// signChallenge issues the short-lived token that stands in for a session
+// between the password step and the second-factor step of a login.
+func signChallenge(secret string, id primitive.ObjectID) (string, error) {
+	claims := challengeClaims{
+		ID: id.Hex(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTTL)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+func parseChallenge(secret, tokenString string) (primitive.ObjectID, error) {
+	claims := &challengeClaims{}
+	token, err := jwt.ParseWithClaims(strings.TrimSpace(tokenString), claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secret), nil
+	}, jwt.WithAudience(challengeAudience))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, jwt.ErrTokenInvalidClaims
+	}
+	return primitive.ObjectIDFromHex(claims.ID)
+}
@@ -3,10 +3,12 @@ package controllers
 import (
 	"brolink-server/app"
 	"brolink-server/middleware"
+	"brolink-server/models"
 	"brolink-server/services"
 	"context"
 	"fmt"
 	"io"
+	"log"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -15,6 +17,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type UploadController struct {
@@ -33,7 +37,7 @@ type uploadResponse struct {
 }
 
 func (uc *UploadController) UploadImage(c *fiber.Ctx) error {
-	_, ok := middleware.CurrentUser(c)
+	userCtx, ok := middleware.CurrentUser(c)
 	if !ok {
 		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
 	}
@@ -68,6 +72,7 @@ func (uc *UploadController) UploadImage(c *fiber.Ctx) error {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 			if upload, err := client.UploadImage(ctx, buffer, mime); err == nil {
+				uc.recordUpload(ctx, userCtx.ID, upload.SecureURL, upload.PublicID, models.StorageCloudinary)
 				return c.JSON(uploadResponse{
 					Message:  "File uploaded successfully",
 					URL:      upload.SecureURL,
@@ -93,6 +98,10 @@ func (uc *UploadController) UploadImage(c *fiber.Ctx) error {
 	}
 	url := fmt.Sprintf("%s://%s/uploads/%s", c.Protocol(), host, filename)
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uc.recordUpload(ctx, userCtx.ID, url, filename, models.StorageLocal)
+
 	return c.JSON(uploadResponse{
 		Message:  "File uploaded successfully",
 		URL:      url,
@@ -101,7 +110,7 @@ func (uc *UploadController) UploadImage(c *fiber.Ctx) error {
 }
 
 func (uc *UploadController) DeleteImage(c *fiber.Ctx) error {
-	_, ok := middleware.CurrentUser(c)
+	userCtx, ok := middleware.CurrentUser(c)
 	if !ok {
 		return respondError(c, fiber.StatusUnauthorized, "Unauthorized")
 	}
@@ -131,6 +140,7 @@ func (uc *UploadController) DeleteImage(c *fiber.Ctx) error {
 			if err := client.DeleteImage(ctx, publicID); err != nil {
 				return respondError(c, fiber.StatusInternalServerError, "Failed to delete image")
 			}
+			uc.forgetUpload(ctx, userCtx.ID, payload.URL)
 			return c.JSON(fiber.Map{"message": "Image deleted successfully"})
 		}
 	}
@@ -140,9 +150,32 @@ func (uc *UploadController) DeleteImage(c *fiber.Ctx) error {
 		_ = os.Remove(filepath.Join(uc.UploadsDir, filename))
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uc.forgetUpload(ctx, userCtx.ID, payload.URL)
+
 	return c.JSON(fiber.Map{"message": "Image deleted successfully"})
 }
 
+// recordUpload remembers who uploaded a file so it shows up in their data
+// export and is removed with their account.
+func (uc *UploadController) recordUpload(ctx context.Context, userID primitive.ObjectID, url, filename, storage string) {
+	_, err := uc.State.Mongo.Uploads().InsertOne(ctx, models.Upload{
+		User:      userID,
+		URL:       url,
+		Filename:  filename,
+		Storage:   storage,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if err != nil {
+		log.Printf("record upload %s: %v", filename, err)
+	}
+}
+
+func (uc *UploadController) forgetUpload(ctx context.Context, userID primitive.ObjectID, url string) {
+	_, _ = uc.State.Mongo.Uploads().DeleteMany(ctx, bson.M{"user": userID, "url": url})
+}
+
 func localFilename(original string) string {
 	ext := filepath.Ext(original)
 	name := strings.TrimSuffix(filepath.Base(original), ext)
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"brolink-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bentoCacheTTL is how long a public page stays cached when none of its
+// widgets is about to appear or disappear.
+const bentoCacheTTL = 60 * time.Second
+
+// visibleBento returns the page as a visitor sees it at now: widgets outside
+// their visibility window are dropped along with their layout items. It also
+// returns how long that view stays valid, so the cache expires exactly when
+// the next widget appears or disappears.
+func visibleBento(config *models.BentoConfig, now time.Time) (*models.BentoConfig, time.Duration) {
+	ttl := bentoCacheTTL
+	shorten := func(at *time.Time) {
+		if at != nil && at.After(now) && at.Sub(now) < ttl {
+			ttl = at.Sub(now)
+		}
+	}
+
+	hidden := map[string]bool{}
+	widgets := make([]models.Widget, 0, len(config.Widgets))
+	for _, w := range config.Widgets {
+		shorten(w.VisibleFrom)
+		shorten(w.VisibleUntil)
+		if !w.VisibleAt(now) {
+			hidden[w.ID] = true
+			continue
+		}
+		widgets = append(widgets, w)
+	}
+	if len(hidden) == 0 {
+		return config, ttl
+	}
+
+	visible := *config
+	visible.Widgets = widgets
+	visible.Layouts = make(map[string]interface{}, len(config.Layouts))
+	for breakpoint, raw := range config.Layouts {
+		visible.Layouts[breakpoint] = withoutLayoutItems(raw, hidden)
+	}
+	return &visible, ttl
+}
+
+// withoutLayoutItems drops the items placing hidden widgets from one
+// breakpoint's layout. Layouts read from Mongo and from the cache decode to
+// different types, so both are handled; anything else is left as is.
+func withoutLayoutItems(raw interface{}, hidden map[string]bool) interface{} {
+	var items []interface{}
+	switch list := raw.(type) {
+	case primitive.A:
+		items = list
+	case []interface{}:
+		items = list
+	default:
+		return raw
+	}
+
+	kept := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if hidden[layoutItemID(item)] {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+func layoutItemID(item interface{}) string {
+	switch doc := item.(type) {
+	case map[string]interface{}:
+		id, _ := doc["i"].(string)
+		return id
+	case primitive.M:
+		id, _ := doc["i"].(string)
+		return id
+	case primitive.D:
+		for _, e := range doc {
+			if e.Key == "i" {
+				id, _ := e.Value.(string)
+				return id
+			}
+		}
+	}
+	return ""
+}
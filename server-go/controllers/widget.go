@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"brolink-server/models"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxWidgetIDLen    = 64
+	maxWidgetURLLen   = 2048
+	maxWidgetTitleLen = 200
+	maxCTATextLen     = 40
+	maxHeadingLen     = 120
+	maxNoteLen        = 5000
+	maxGalleryImages  = 12
+	maxCaptionLen     = 200
+	maxSocialProfiles = 12
+	maxWidgetLabelLen = 120
+)
+
+var (
+	youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	vimeoID   = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// Schemes a link widget may point at. Images and embeds are http(s) only.
+var linkSchemes = []string{"http", "https", "mailto", "tel"}
+
+// normalizeWidget checks w against the schema for its type, reporting
+// problems under field, and clears any payload that belongs to a different
+// type. Untyped widgets are links.
+func normalizeWidget(v *bentoValidator, field string, w *models.Widget) {
+	w.ID = strings.TrimSpace(w.ID)
+	switch {
+	case w.ID == "":
+		v.add(field+".id", "is required")
+	case len(w.ID) > maxWidgetIDLen:
+		v.add(field+".id", "must be at most %d characters", maxWidgetIDLen)
+	}
+	if !models.ValidWidgetSize(w.Size) {
+		v.add(field+".size", "must be one of %s", strings.Join(models.WidgetSizes, ", "))
+	}
+	if w.VisibleFrom != nil && w.VisibleUntil != nil && !w.VisibleUntil.After(*w.VisibleFrom) {
+		v.add(field+".visibleUntil", "must be after visibleFrom")
+	}
+
+	kind := w.Kind()
+	if kind != models.WidgetLink {
+		w.URL, w.CustomTitle, w.CustomImage, w.CTAText, w.ImageFit = "", "", "", "", ""
+	}
+	if kind != models.WidgetHeading {
+		w.Heading = nil
+	}
+	if kind != models.WidgetNote {
+		w.Note = nil
+	}
+	if kind != models.WidgetGallery {
+		w.Gallery = nil
+	}
+	if kind != models.WidgetVideo {
+		w.Video = nil
+	}
+	if kind != models.WidgetMap {
+		w.Map = nil
+	}
+	if kind != models.WidgetSocial {
+		w.Social = nil
+	}
+	if kind != models.WidgetCountdown {
+		w.Countdown = nil
+	}
+
+	switch kind {
+	case models.WidgetLink:
+		// Link URLs may be blank while the card is still being filled in.
+		if w.URL != "" {
+			v.url(field+".url", w.URL, linkSchemes)
+		}
+		if w.CustomImage != "" {
+			v.url(field+".customImage", w.CustomImage, nil)
+		}
+		v.maxLen(field+".customTitle", w.CustomTitle, maxWidgetTitleLen)
+		v.maxLen(field+".ctaText", w.CTAText, maxCTATextLen)
+		if w.ImageFit != "" && w.ImageFit != "cover" && w.ImageFit != "contain" {
+			v.add(field+".imageFit", "must be cover or contain")
+		}
+	case models.WidgetHeading:
+		if w.Heading == nil {
+			v.add(field+".heading", "is required")
+			return
+		}
+		v.required(field+".heading.text", w.Heading.Text)
+		v.maxLen(field+".heading.text", w.Heading.Text, maxHeadingLen)
+		v.maxLen(field+".heading.subtitle", w.Heading.Subtitle, maxHeadingLen)
+	case models.WidgetNote:
+		if w.Note == nil {
+			v.add(field+".note", "is required")
+			return
+		}
+		v.required(field+".note.markdown", w.Note.Markdown)
+		v.maxLen(field+".note.markdown", w.Note.Markdown, maxNoteLen)
+	case models.WidgetGallery:
+		if w.Gallery == nil || len(w.Gallery.Images) == 0 {
+			v.add(field+".gallery.images", "needs at least one image")
+			return
+		}
+		if len(w.Gallery.Images) > maxGalleryImages {
+			v.add(field+".gallery.images", "allows at most %d images", maxGalleryImages)
+		}
+		for i, image := range w.Gallery.Images {
+			imageField := indexed(field+".gallery.images", i)
+			v.url(imageField+".url", image.URL, nil)
+			v.maxLen(imageField+".caption", image.Caption, maxCaptionLen)
+		}
+	case models.WidgetVideo:
+		if w.Video == nil {
+			v.add(field+".video.url", "is required")
+			return
+		}
+		provider, id, ok := parseVideoURL(w.Video.URL)
+		if !ok {
+			v.add(field+".video.url", "must be a YouTube or Vimeo link")
+			return
+		}
+		w.Video.Provider, w.Video.VideoID = provider, id
+	case models.WidgetMap:
+		if w.Map == nil {
+			v.add(field+".map", "is required")
+			return
+		}
+		if w.Map.Latitude < -90 || w.Map.Latitude > 90 {
+			v.add(field+".map.latitude", "must be between -90 and 90")
+		}
+		if w.Map.Longitude < -180 || w.Map.Longitude > 180 {
+			v.add(field+".map.longitude", "must be between -180 and 180")
+		}
+		if w.Map.Zoom < 0 || w.Map.Zoom > 20 {
+			v.add(field+".map.zoom", "must be between 0 and 20")
+		}
+		v.maxLen(field+".map.label", w.Map.Label, maxWidgetLabelLen)
+	case models.WidgetSocial:
+		if w.Social == nil || len(w.Social.Profiles) == 0 {
+			v.add(field+".social.profiles", "needs at least one profile")
+			return
+		}
+		if len(w.Social.Profiles) > maxSocialProfiles {
+			v.add(field+".social.profiles", "allows at most %d profiles", maxSocialProfiles)
+		}
+		for i, profile := range w.Social.Profiles {
+			profileField := indexed(field+".social.profiles", i)
+			if !models.ValidSocialNetwork(profile.Network) {
+				v.add(profileField+".network", "must be one of %s", strings.Join(models.SocialNetworks, ", "))
+			}
+			v.url(profileField+".url", profile.URL, nil)
+		}
+	case models.WidgetCountdown:
+		if w.Countdown == nil || w.Countdown.Target.IsZero() {
+			v.add(field+".countdown.target", "is required")
+			return
+		}
+		v.maxLen(field+".countdown.label", w.Countdown.Label, maxWidgetLabelLen)
+		v.maxLen(field+".countdown.doneText", w.Countdown.DoneText, maxWidgetLabelLen)
+	default:
+		v.add(field+".type", "unknown widget type %q", w.Type)
+	}
+}
+
+// parseVideoURL extracts the provider and video id from a YouTube or Vimeo
+// watch, share or embed link.
+func parseVideoURL(raw string) (string, string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", "", false
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+
+	switch host {
+	case "youtube.com", "youtube-nocookie.com":
+		id := parsed.Query().Get("v")
+		if len(segments) == 2 && (segments[0] == "embed" || segments[0] == "shorts" || segments[0] == "live") {
+			id = segments[1]
+		}
+		if youtubeID.MatchString(id) {
+			return models.VideoYouTube, id, true
+		}
+	case "youtu.be":
+		if len(segments) == 1 && youtubeID.MatchString(segments[0]) {
+			return models.VideoYouTube, segments[0], true
+		}
+	case "vimeo.com":
+		if len(segments) >= 1 && vimeoID.MatchString(segments[0]) {
+			return models.VideoVimeo, segments[0], true
+		}
+	case "player.vimeo.com":
+		if len(segments) == 2 && segments[0] == "video" && vimeoID.MatchString(segments[1]) {
+			return models.VideoVimeo, segments[1], true
+		}
+	}
+	return "", "", false
+}
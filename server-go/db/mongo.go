@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -42,32 +43,262 @@ func (m *Mongo) BentoConfigs() *mongo.Collection {
 	return m.DB.Collection("bentoconfigs")
 }
 
+func (m *Mongo) BentoDrafts() *mongo.Collection {
+	return m.DB.Collection("bentodrafts")
+}
+
+func (m *Mongo) BentoRevisions() *mongo.Collection {
+	return m.DB.Collection("bentorevisions")
+}
+
 func (m *Mongo) Clicks() *mongo.Collection {
 	return m.DB.Collection("clickevents")
 }
 
+func (m *Mongo) PageViews() *mongo.Collection {
+	return m.DB.Collection("pageviews")
+}
+
+func (m *Mongo) AnalyticsRollups() *mongo.Collection {
+	return m.DB.Collection("analyticsrollups")
+}
+
+// RollupState records how far the analytics rollups have got.
+func (m *Mongo) RollupState() *mongo.Collection {
+	return m.DB.Collection("rollupstate")
+}
+
+func (m *Mongo) Sessions() *mongo.Collection {
+	return m.DB.Collection("sessions")
+}
+
+func (m *Mongo) ActionTokens() *mongo.Collection {
+	return m.DB.Collection("actiontokens")
+}
+
+func (m *Mongo) LockoutEvents() *mongo.Collection {
+	return m.DB.Collection("lockoutevents")
+}
+
+func (m *Mongo) APIKeys() *mongo.Collection {
+	return m.DB.Collection("apikeys")
+}
+
+func (m *Mongo) UsernameRedirects() *mongo.Collection {
+	return m.DB.Collection("usernameredirects")
+}
+
+func (m *Mongo) Uploads() *mongo.Collection {
+	return m.DB.Collection("uploads")
+}
+
+func (m *Mongo) AuditEvents() *mongo.Collection {
+	return m.DB.Collection("auditevents")
+}
+
+func (m *Mongo) SigningKeys() *mongo.Collection {
+	return m.DB.Collection("signingkeys")
+}
+
+// WithTransaction runs fn inside a transaction. Standalone servers don't
+// support transactions, so there fn runs directly against sessCtx without
+// one.
+func (m *Mongo) WithTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	if isTransactionUnsupported(err) {
+		return mongo.WithSession(ctx, session, fn)
+	}
+	return err
+}
+
+func isTransactionUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		// IllegalOperation: "Transaction numbers are only allowed on a
+		// replica set member or mongos".
+		return cmdErr.Code == 20
+	}
+	return false
+}
+
 func (m *Mongo) EnsureIndexes(ctx context.Context) error {
 	unique := true
+	sparse := true
 	users := m.Users()
 	_, err := users.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "email", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
 		{Keys: bson.D{{Key: "username", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{
+			Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: &options.IndexOptions{
+				Unique:                  &unique,
+				PartialFilterExpression: bson.M{"identities.subject": bson.M{"$exists": true}},
+			},
+		},
+		{
+			Keys: bson.D{{Key: "passkeys.id", Value: 1}},
+			Options: &options.IndexOptions{
+				Unique:                  &unique,
+				PartialFilterExpression: bson.M{"passkeys.id": bson.M{"$exists": true}},
+			},
+		},
+		{Keys: bson.D{{Key: "delete_after", Value: 1}}, Options: &options.IndexOptions{Sparse: &sparse}},
 	})
 	if err != nil {
 		return err
 	}
 
+	// Pages used to be one per user; those unique indexes would now block
+	// a second page.
 	configs := m.BentoConfigs()
+	for _, name := range []string{"user_1", "username_1"} {
+		if err := dropIndexIfExists(ctx, configs, name); err != nil {
+			return err
+		}
+	}
 	_, err = configs.Indexes().CreateMany(ctx, []mongo.IndexModel{
-		{Keys: bson.D{{Key: "user", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
-		{Keys: bson.D{{Key: "username", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "slug", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "slug", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
 	})
 	if err != nil {
 		return err
 	}
+	drafts := m.BentoDrafts()
+	if err := dropIndexIfExists(ctx, drafts, "user_1"); err != nil {
+		return err
+	}
+	_, err = drafts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "slug", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "publish_at", Value: 1}}, Options: &options.IndexOptions{Sparse: &sparse}},
+	})
+	if err != nil {
+		return err
+	}
+
+	revisions := m.BentoRevisions()
+	if err := dropIndexIfExists(ctx, revisions, "user_1__id_-1"); err != nil {
+		return err
+	}
+	_, err = revisions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "slug", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
 	clicks := m.Clicks()
 	_, err = clicks.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "owner_username", Value: 1}, {Key: "widget_id", Value: 1}}},
+		{Keys: bson.D{{Key: "clicked_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.PageViews().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_username", Value: 1}, {Key: "viewed_at", Value: 1}}},
+		{Keys: bson.D{{Key: "viewed_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.AnalyticsRollups().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "owner_username", Value: 1},
+				{Key: "day", Value: 1},
+				{Key: "page", Value: 1},
+				{Key: "widget_id", Value: 1},
+			},
+			Options: &options.IndexOptions{Unique: &unique},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	expireNow := int32(0)
+	sessions := m.Sessions()
+	_, err = sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "refresh_hash", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "prev_refresh_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: &options.IndexOptions{ExpireAfterSeconds: &expireNow}},
+	})
+	if err != nil {
+		return err
+	}
+
+	tokens := m.ActionTokens()
+	_, err = tokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: &options.IndexOptions{ExpireAfterSeconds: &expireNow}},
+	})
+	if err != nil {
+		return err
+	}
+
+	apiKeys := m.APIKeys()
+	_, err = apiKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "user", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	redirects := m.UsernameRedirects()
+	_, err = redirects.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: &options.IndexOptions{Unique: &unique}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: &options.IndexOptions{ExpireAfterSeconds: &expireNow}},
+	})
+	if err != nil {
+		return err
+	}
+
+	uploads := m.Uploads()
+	_, err = uploads.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}}},
+		{Keys: bson.D{{Key: "url", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	signingKeys := m.SigningKeys()
+	_, err = signingKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "alg", Value: 1}, {Key: "retire_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: &options.IndexOptions{ExpireAfterSeconds: &expireNow}},
+	})
+	if err != nil {
+		return err
+	}
+
+	audit := m.AuditEvents()
+	_, err = audit.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	lockouts := m.LockoutEvents()
+	_, err = lockouts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "createdAt", Value: -1}}},
 	})
 	if err != nil {
 		return err
@@ -75,6 +306,16 @@ func (m *Mongo) EnsureIndexes(ctx context.Context) error {
 	return nil
 }
 
+func dropIndexIfExists(ctx context.Context, coll *mongo.Collection, name string) error {
+	_, err := coll.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27) {
+		// NamespaceNotFound or IndexNotFound: nothing to drop.
+		return nil
+	}
+	return err
+}
+
 func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(ctx, 5*time.Second)
 }
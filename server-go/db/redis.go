@@ -3,6 +3,9 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -35,6 +38,21 @@ func (r *Redis) GetJSON(ctx context.Context, key string, dest any) (bool, error)
 	return true, nil
 }
 
+// TakeJSON reads and deletes key in one step, for single-use values.
+func (r *Redis) TakeJSON(ctx context.Context, key string, dest any) (bool, error) {
+	val, err := r.Client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(val), dest); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
 func (r *Redis) SetJSON(ctx context.Context, key string, value any, ttl time.Duration) error {
 	payload, err := json.Marshal(value)
 	if err != nil {
@@ -46,3 +64,51 @@ func (r *Redis) SetJSON(ctx context.Context, key string, value any, ttl time.Dur
 func (r *Redis) Del(ctx context.Context, key string) error {
 	return r.Client.Del(ctx, key).Err()
 }
+
+// SlidingWindowAdd records an event at now in the sorted set at key and
+// returns how many events fall inside the trailing window.
+func (r *Redis) SlidingWindowAdd(ctx context.Context, key string, now time.Time, window time.Duration) (int64, error) {
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+	min := strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+
+	var card *redis.IntCmd
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+min)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: member})
+		card = pipe.ZCard(ctx, key)
+		pipe.PExpire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+// TTL returns the remaining lifetime of key, or zero if it does not exist.
+func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.Client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Incr increments the counter at key, setting ttl when it is created.
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	val, err := r.Client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if val == 1 {
+		_ = r.Client.PExpire(ctx, key, ttl).Err()
+	}
+	return val, nil
+}
+
+func (r *Redis) SetFlag(ctx context.Context, key string, ttl time.Duration) error {
+	return r.Client.Set(ctx, key, "1", ttl).Err()
+}
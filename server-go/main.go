@@ -3,9 +3,11 @@ package main
 import (
 	"brolink-server/app"
 	"brolink-server/config"
+	"brolink-server/controllers"
 	"brolink-server/db"
 	"brolink-server/middleware"
 	"brolink-server/routes"
+	"brolink-server/services"
 	"context"
 	"log"
 	"net/http"
@@ -24,6 +26,9 @@ import (
 func main() {
 	_ = godotenv.Load()
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Config invalid: %v", err)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -36,15 +41,29 @@ func main() {
 		log.Fatalf("MongoDB index init failed: %v", err)
 	}
 
+	keys, err := services.NewKeyRing(ctx, cfg, mongo.SigningKeys())
+	if err != nil {
+		log.Fatalf("JWT key init failed: %v", err)
+	}
+	go keys.Run(context.Background())
+
+	passwords, err := services.NewPasswords(cfg)
+	if err != nil {
+		log.Fatalf("Password policy init failed: %v", err)
+	}
+
 	redisClient, err := db.ConnectRedis(cfg.RedisURL)
 	if err != nil {
 		log.Fatalf("Redis init failed: %v", err)
 	}
 
 	state := &app.State{
-		Config: cfg,
-		Mongo:  mongo,
-		Redis:  redisClient,
+		Config:    cfg,
+		Mongo:     mongo,
+		Redis:     redisClient,
+		Mailer:    services.NewMailer(cfg),
+		Keys:      keys,
+		Passwords: passwords,
 	}
 
 	app := fiber.New(fiber.Config{
@@ -59,8 +78,9 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     strings.Join(cfg.AllowedOrigins, ","),
 		AllowCredentials: true,
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Content-Type, Authorization",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:     "Content-Type, Authorization, If-Match",
+		ExposeHeaders:    "ETag",
 	}))
 
 	uploadsDir := filepath.Join(".", "uploads")
@@ -73,6 +93,12 @@ func main() {
 		Browse: false,
 	}))
 
+	go controllers.RunAccountPurger(context.Background(), state, uploadsDir)
+	go controllers.RunScheduledPublisher(context.Background(), state)
+	go controllers.RunAnalyticsRollup(context.Background(), state)
+
+	routes.RegisterWellKnown(app, state)
+
 	api := app.Group("/api")
 	routes.Register(api, state, uploadsDir)
 
@@ -1,27 +1,46 @@
 package middleware
 
 import (
-	"brolink-server/config"
+	"brolink-server/app"
+	"brolink-server/models"
+	"context"
+	"crypto/sha256"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// APIKeyPrefix marks bearer tokens that are personal access tokens rather
+// than session JWTs.
+const APIKeyPrefix = "blk_"
+
 type AuthUser struct {
-	ID   primitive.ObjectID
-	Role string
+	ID        primitive.ObjectID
+	Role      string
+	SessionID primitive.ObjectID
+	MFA       bool
+	APIKey    *models.APIKey
 }
 
 type Claims struct {
-	ID   string `json:"id"`
-	Role string `json:"role"`
+	ID        string `json:"id"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	MFA       bool   `json:"mfa,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func RequireAuth(cfg *config.Config) fiber.Handler {
+// RequireAuth accepts a session JWT or a personal access token. API keys are
+// only let through routes that name the scopes they need, and must hold all
+// of them; session JWTs ignore scopes.
+func RequireAuth(state *app.State, scopes ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -33,13 +52,17 @@ func RequireAuth(cfg *config.Config) fiber.Handler {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		if strings.HasPrefix(tokenString, APIKeyPrefix) {
+			user, status, message := authenticateAPIKey(state, tokenString, scopes)
+			if user == nil {
+				return c.Status(status).JSON(fiber.Map{"message": message})
 			}
-			return []byte(cfg.JWTSecret), nil
-		})
+			c.Locals("user", user)
+			return c.Next()
+		}
+
+		claims := &Claims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, state.Keys.Keyfunc, jwt.WithIssuer(state.Keys.Issuer()))
 		if err != nil || !token.Valid {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
 		}
@@ -48,12 +71,109 @@ func RequireAuth(cfg *config.Config) fiber.Handler {
 		if err != nil {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
 		}
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+		}
+		if !sessionActive(state, objID, sessionID) {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Session revoked"})
+		}
 
-		c.Locals("user", &AuthUser{ID: objID, Role: claims.Role})
+		c.Locals("user", &AuthUser{ID: objID, Role: claims.Role, SessionID: sessionID, MFA: claims.MFA})
 		return c.Next()
 	}
 }
 
+// authenticateAPIKey resolves a personal access token to its owner. On
+// failure it returns the status and message to respond with.
+func authenticateAPIKey(state *app.State, token string, scopes []string) (*AuthUser, int, string) {
+	if len(scopes) == 0 {
+		return nil, http.StatusForbidden, "API keys cannot access this endpoint"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var key models.APIKey
+	err := state.Mongo.APIKeys().FindOne(ctx, bson.M{"hash": HashAPIKey(token)}).Decode(&key)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Unauthorized"
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Time().Before(now) {
+		return nil, http.StatusUnauthorized, "API key expired"
+	}
+	for _, scope := range scopes {
+		if !key.HasScope(scope) {
+			return nil, http.StatusForbidden, "API key is missing scope " + scope
+		}
+	}
+
+	var owner struct {
+		Role      string `bson:"role"`
+		IsBlocked bool   `bson:"is_blocked"`
+	}
+	err = state.Mongo.Users().FindOne(ctx, bson.M{"_id": key.User}).Decode(&owner)
+	if err == mongo.ErrNoDocuments || (err == nil && owner.IsBlocked) {
+		return nil, http.StatusUnauthorized, "Unauthorized"
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Auth failed"
+	}
+
+	// Only touch last_used_at about once a minute per key.
+	_, _ = state.Mongo.APIKeys().UpdateOne(ctx,
+		bson.M{"_id": key.ID, "$or": []bson.M{
+			{"last_used_at": bson.M{"$exists": false}},
+			{"last_used_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now.Add(-time.Minute))}},
+		}},
+		bson.M{"$set": bson.M{"last_used_at": primitive.NewDateTimeFromTime(now)}},
+	)
+
+	return &AuthUser{ID: key.User, Role: owner.Role, APIKey: &key}, 0, ""
+}
+
+// HashAPIKey returns the at-rest form of a personal access token.
+func HashAPIKey(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// sessionActive reports whether the session backing an access token still
+// exists and has not been revoked or expired.
+func sessionActive(state *app.State, userID, sessionID primitive.ObjectID) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := state.Mongo.Sessions().CountDocuments(ctx, bson.M{
+		"_id":        sessionID,
+		"user":       userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	return err == nil && count > 0
+}
+
+// BearerUserID returns the user behind a session JWT in the Authorization
+// header, if there is a valid one. It neither requires a token nor checks
+// the session is still live, so it is only for public routes that want to
+// recognise the caller, never to authorise them.
+func BearerUserID(state *app.State, c *fiber.Ctx) (primitive.ObjectID, bool) {
+	tokenString := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer "))
+	if tokenString == "" || strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return primitive.NilObjectID, false
+	}
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, state.Keys.Keyfunc, jwt.WithIssuer(state.Keys.Issuer()))
+	if err != nil || !token.Valid {
+		return primitive.NilObjectID, false
+	}
+	objID, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return objID, true
+}
+
 func CurrentUser(c *fiber.Ctx) (*AuthUser, bool) {
 	user, ok := c.Locals("user").(*AuthUser)
 	return user, ok
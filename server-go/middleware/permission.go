@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"brolink-server/app"
+	"brolink-server/models"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequirePermission lets the request through only when the caller's role
+// grants every listed permission. It must run after RequireAuth. Staff
+// sessions also need a second factor when REQUIRE_ADMIN_2FA is set.
+func RequirePermission(state *app.State, perms ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userCtx, ok := CurrentUser(c)
+		if !ok {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+		}
+		for _, perm := range perms {
+			if !models.HasPermission(userCtx.Role, perm) {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{"message": "Insufficient permissions"})
+			}
+		}
+		if state.Config.RequireAdmin2FA && !userCtx.MFA {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"message": "Two-factor authentication required"})
+		}
+		return c.Next()
+	}
+}
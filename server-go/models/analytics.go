@@ -11,6 +11,7 @@ type ClickEvent struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty"        json:"id"`
 	WidgetID       string             `bson:"widget_id"            json:"widget_id"`
 	OwnerUsername  string             `bson:"owner_username"       json:"owner_username"`
+	Page           string             `bson:"page,omitempty"       json:"page,omitempty"`
 	URL            string             `bson:"url"                  json:"url"`
 	CustomTitle    string             `bson:"custom_title,omitempty"  json:"custom_title,omitempty"`
 	CustomImage    string             `bson:"custom_image,omitempty"  json:"custom_image,omitempty"`
@@ -24,20 +25,40 @@ type ClickEvent struct {
 	ClickedAt      time.Time          `bson:"clicked_at"           json:"clicked_at"`
 }
 
-// WidgetClickStat is the per-widget aggregation result.
+// PageView is stored when a public page is served to a visitor, at most
+// once per visitor and page in a short window. Views are not located.
+type PageView struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"   json:"id"`
+	OwnerUsername  string             `bson:"owner_username"  json:"owner_username"`
+	Page           string             `bson:"page,omitempty"  json:"page,omitempty"`
+	IPHash         string             `bson:"ip_hash"         json:"ip_hash"`
+	ReferrerDomain string             `bson:"referrer_domain" json:"referrer_domain"`
+	DeviceType     string             `bson:"device_type"     json:"device_type"`
+	ViewedAt       time.Time          `bson:"viewed_at"       json:"viewed_at"`
+}
+
+// WidgetClickStat is the per-widget aggregation result. DailyUnique sums
+// the distinct visitors of each UTC day, so a visitor who returns on
+// another day is counted again. Views counts views of the page the widget
+// is on, and CTR is Total / Views.
 type WidgetClickStat struct {
-	WidgetID    string `bson:"_id"          json:"widget_id"`
-	URL         string `bson:"url"          json:"url"`
-	CustomTitle string `bson:"custom_title" json:"custom_title"`
-	CustomImage string `bson:"custom_image" json:"custom_image"`
-	Total       int64  `bson:"total"        json:"total"`
-	Unique      int64  `bson:"unique"       json:"unique"`
+	WidgetID    string  `bson:"_id"          json:"widget_id"`
+	Page        string  `bson:"page"         json:"page,omitempty"`
+	URL         string  `bson:"url"          json:"url"`
+	CustomTitle string  `bson:"custom_title" json:"custom_title"`
+	CustomImage string  `bson:"custom_image" json:"custom_image"`
+	Total       int64   `bson:"total"        json:"total"`
+	DailyUnique int64   `bson:"unique"       json:"daily_unique"`
+	Views       int64   `bson:"-"            json:"views"`
+	CTR         float64 `bson:"-"            json:"ctr"`
 }
 
-// TimelinePoint is one day's click total.
+// TimelinePoint is one bucket's click total alongside its page views.
 type TimelinePoint struct {
-	Date  string `bson:"_id"   json:"date"` // "2024-02-25"
-	Total int64  `bson:"total" json:"total"`
+	Date  string  `bson:"_id"   json:"date"` // "2024-02-25"
+	Total int64   `bson:"total" json:"total"`
+	Views int64   `bson:"-"     json:"views"`
+	CTR   float64 `bson:"-"     json:"ctr"`
 }
 
 // ReferrerStat groups clicks by referrer domain.
@@ -63,3 +84,40 @@ type GeoStat struct {
 	CountryCode string `bson:"country_code" json:"country_code"`
 	Count       int64  `bson:"count"        json:"count"`
 }
+
+// AnalyticsRollup holds one UTC day of counters for a widget, so dashboards
+// don't have to aggregate every raw event. Each page also gets a rollup
+// with an empty WidgetID that carries its views. Unique counts distinct
+// visitors within the day only.
+type AnalyticsRollup struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"          json:"-"`
+	OwnerUsername string             `bson:"owner_username"         json:"owner_username"`
+	Page          string             `bson:"page"                   json:"page"`
+	WidgetID      string             `bson:"widget_id"              json:"widget_id"`
+	Day           string             `bson:"day"                    json:"day"` // "2024-02-25"
+	URL           string             `bson:"url,omitempty"          json:"url,omitempty"`
+	CustomTitle   string             `bson:"custom_title,omitempty" json:"custom_title,omitempty"`
+	CustomImage   string             `bson:"custom_image,omitempty" json:"custom_image,omitempty"`
+	Clicks        int64              `bson:"clicks"                 json:"clicks"`
+	Unique        int64              `bson:"unique"                 json:"unique"`
+	Views         int64              `bson:"views"                  json:"views"`
+	Devices       []RollupCount      `bson:"devices,omitempty"      json:"devices,omitempty"`
+	Referrers     []RollupCount      `bson:"referrers,omitempty"    json:"referrers,omitempty"`
+	Geo           []GeoCount         `bson:"geo,omitempty"          json:"geo,omitempty"`
+}
+
+// RollupCount is one device type's or referrer domain's share of a rollup.
+type RollupCount struct {
+	Key   string `bson:"key"   json:"key"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// GeoCount is one location's share of a rollup, by country and down to
+// city where known.
+type GeoCount struct {
+	Country     string `bson:"country"                json:"country"`
+	CountryCode string `bson:"country_code,omitempty" json:"country_code,omitempty"`
+	Region      string `bson:"region,omitempty"       json:"region,omitempty"`
+	City        string `bson:"city,omitempty"         json:"city,omitempty"`
+	Count       int64  `bson:"count"                  json:"count"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	ScopeBentoWrite    = "bento:write"
+	ScopeMediaWrite    = "media:write"
+	ScopeAnalyticsRead = "analytics:read"
+	ScopeProfileRead   = "profile:read"
+)
+
+// APIKeyScopes lists every scope a personal access token may be granted.
+var APIKeyScopes = []string{ScopeBentoWrite, ScopeMediaWrite, ScopeAnalyticsRead, ScopeProfileRead}
+
+// APIKey is a user-created personal access token. Only a hash of the token
+// is stored; Prefix is kept in clear so users can tell keys apart.
+type APIKey struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	User       primitive.ObjectID  `bson:"user" json:"-"`
+	Name       string              `bson:"name" json:"name"`
+	Prefix     string              `bson:"prefix" json:"prefix"`
+	Hash       string              `bson:"hash" json:"-"`
+	Scopes     []string            `bson:"scopes" json:"scopes"`
+	ExpiresAt  *primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *primitive.DateTime `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  primitive.DateTime  `bson:"createdAt" json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Audit actions.
+const (
+	AuditSignup           = "signup"
+	AuditLogin            = "login"
+	AuditLogout           = "logout"
+	AuditPasswordReset    = "password_reset"
+	AuditEmailChange      = "email_change"
+	AuditUsernameChange   = "username_change"
+	AuditTwoFactorEnable  = "2fa_enable"
+	AuditTwoFactorDisable = "2fa_disable"
+	AuditPasskeyAdd       = "passkey_add"
+	AuditPasskeyRemove    = "passkey_remove"
+	AuditAPIKeyCreate     = "api_key_create"
+	AuditAPIKeyDelete     = "api_key_delete"
+	AuditAccountDelete    = "account_delete"
+	AuditBlock            = "block"
+	AuditUnblock          = "unblock"
+	AuditRoleChange       = "role_change"
+	AuditSessionsRevoke   = "sessions_revoke"
+	AuditUnlock           = "unlock"
+)
+
+// Audit results.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is one entry in the append-only audit log. Actor is who did
+// it and Target who it was done to; both are the same user for
+// self-service actions. Email is kept for failed logins that match no
+// account.
+type AuditEvent struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Action    string              `bson:"action" json:"action"`
+	Result    string              `bson:"result" json:"result"`
+	Actor     *primitive.ObjectID `bson:"actor,omitempty" json:"actor,omitempty"`
+	Target    *primitive.ObjectID `bson:"target,omitempty" json:"target,omitempty"`
+	Email     string              `bson:"email,omitempty" json:"email,omitempty"`
+	IPHash    string              `bson:"ip_hash" json:"ip_hash"`
+	UserAgent string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Details   map[string]string   `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt primitive.DateTime  `bson:"createdAt" json:"created_at"`
+}
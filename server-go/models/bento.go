@@ -1,23 +1,80 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Widget is one card on a bento page. Type selects which payload is used;
+// link widgets keep their fields at the top level so documents written
+// before widgets were typed still decode as links. VisibleFrom and
+// VisibleUntil, when set, limit when the widget appears on the public page.
 type Widget struct {
 	ID          string `bson:"id" json:"id"`
+	Type        string `bson:"type,omitempty" json:"type,omitempty"`
 	Size        string `bson:"size" json:"size"`
 	URL         string `bson:"url,omitempty" json:"url,omitempty"`
 	CustomTitle string `bson:"customTitle,omitempty" json:"customTitle,omitempty"`
 	CustomImage string `bson:"customImage,omitempty" json:"customImage,omitempty"`
 	CTAText     string `bson:"ctaText,omitempty" json:"ctaText,omitempty"`
 	ImageFit    string `bson:"imageFit,omitempty" json:"imageFit,omitempty"`
+
+	VisibleFrom  *time.Time `bson:"visibleFrom,omitempty" json:"visibleFrom,omitempty"`
+	VisibleUntil *time.Time `bson:"visibleUntil,omitempty" json:"visibleUntil,omitempty"`
+
+	Heading   *HeadingWidget   `bson:"heading,omitempty" json:"heading,omitempty"`
+	Note      *NoteWidget      `bson:"note,omitempty" json:"note,omitempty"`
+	Gallery   *GalleryWidget   `bson:"gallery,omitempty" json:"gallery,omitempty"`
+	Video     *VideoWidget     `bson:"video,omitempty" json:"video,omitempty"`
+	Map       *MapWidget       `bson:"map,omitempty" json:"map,omitempty"`
+	Social    *SocialWidget    `bson:"social,omitempty" json:"social,omitempty"`
+	Countdown *CountdownWidget `bson:"countdown,omitempty" json:"countdown,omitempty"`
+}
+
+// Kind returns the widget's type, treating an untyped widget as a link.
+func (w *Widget) Kind() string {
+	if w.Type == "" {
+		return WidgetLink
+	}
+	return w.Type
+}
+
+// VisibleAt reports whether the widget is inside its visibility window at t.
+// The window includes VisibleFrom and excludes VisibleUntil.
+func (w *Widget) VisibleAt(t time.Time) bool {
+	if w.VisibleFrom != nil && t.Before(*w.VisibleFrom) {
+		return false
+	}
+	if w.VisibleUntil != nil && !t.Before(*w.VisibleUntil) {
+		return false
+	}
+	return true
 }
 
+// DefaultPageSlug selects a user's default page wherever a page slug is
+// taken as a query parameter.
+const DefaultPageSlug = "default"
+
+// BentoConfig is a user's page. The same shape is stored twice: the draft
+// the editor syncs to, and the published copy served publicly. Version
+// goes up by one on every draft sync so concurrent editors can detect each
+// other's writes, and a published page carries the version it was
+// published from; pages saved before versioning read as version 0.
+//
+// A user can have several pages. Slug names each one in its URL; the
+// default page has no slug and is the one served at /bento/:username.
 type BentoConfig struct {
-	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
-	User      primitive.ObjectID     `bson:"user" json:"user"`
-	Username  string                 `bson:"username" json:"username"`
-	Widgets   []Widget               `bson:"widgets,omitempty" json:"widgets"`
-	Layouts   map[string]interface{} `bson:"layouts,omitempty" json:"layouts"`
-	CreatedAt *primitive.DateTime    `bson:"createdAt,omitempty" json:"created_at,omitempty"`
-	UpdatedAt *primitive.DateTime    `bson:"updatedAt,omitempty" json:"updated_at,omitempty"`
+	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	User        primitive.ObjectID     `bson:"user" json:"user"`
+	Username    string                 `bson:"username" json:"username"`
+	Slug        string                 `bson:"slug,omitempty" json:"slug"`
+	Title       string                 `bson:"title,omitempty" json:"title,omitempty"`
+	Widgets     []Widget               `bson:"widgets,omitempty" json:"widgets"`
+	Layouts     map[string]interface{} `bson:"layouts,omitempty" json:"layouts"`
+	Version     int64                  `bson:"version" json:"version"`
+	PublishAt   *primitive.DateTime    `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	PublishedAt *primitive.DateTime    `bson:"publishedAt,omitempty" json:"published_at,omitempty"`
+	CreatedAt   *primitive.DateTime    `bson:"createdAt,omitempty" json:"created_at,omitempty"`
+	UpdatedAt   *primitive.DateTime    `bson:"updatedAt,omitempty" json:"updated_at,omitempty"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// LockoutEvent is recorded each time repeated failed logins lock out an
+// account or an IP address.
+type LockoutEvent struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Scope       string              `bson:"scope" json:"scope"` // account | ip
+	Email       string              `bson:"email,omitempty" json:"email,omitempty"`
+	User        *primitive.ObjectID `bson:"user,omitempty" json:"user,omitempty"`
+	IPHash      string              `bson:"ip_hash" json:"ip_hash"`
+	UserAgent   string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Attempts    int64               `bson:"attempts" json:"attempts"`
+	LockedUntil primitive.DateTime  `bson:"locked_until" json:"locked_until"`
+	CreatedAt   primitive.DateTime  `bson:"createdAt" json:"created_at"`
+}
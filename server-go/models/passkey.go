@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/base64"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Passkey is a WebAuthn credential registered to a user. ID is the raw
+// credential ID chosen by the authenticator.
+type Passkey struct {
+	ID              []byte              `bson:"id"`
+	Name            string              `bson:"name"`
+	PublicKey       []byte              `bson:"public_key"`
+	AttestationType string              `bson:"attestation_type,omitempty"`
+	Transports      []string            `bson:"transports,omitempty"`
+	AAGUID          []byte              `bson:"aaguid,omitempty"`
+	SignCount       uint32              `bson:"sign_count"`
+	BackupEligible  bool                `bson:"backup_eligible"`
+	BackupState     bool                `bson:"backup_state"`
+	CreatedAt       primitive.DateTime  `bson:"createdAt"`
+	LastUsedAt      *primitive.DateTime `bson:"last_used_at,omitempty"`
+}
+
+// PasskeyView is what a user sees about one of their passkeys.
+type PasskeyView struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Transports []string            `json:"transports,omitempty"`
+	Synced     bool                `json:"synced"`
+	CreatedAt  primitive.DateTime  `json:"created_at"`
+	LastUsedAt *primitive.DateTime `json:"last_used_at,omitempty"`
+}
+
+// EncodedID is the credential ID as base64url, the form browsers use.
+func (p *Passkey) EncodedID() string {
+	return base64.RawURLEncoding.EncodeToString(p.ID)
+}
+
+func (p *Passkey) View() PasskeyView {
+	return PasskeyView{
+		ID:         p.EncodedID(),
+		Name:       p.Name,
+		Transports: p.Transports,
+		Synced:     p.BackupState,
+		CreatedAt:  p.CreatedAt,
+		LastUsedAt: p.LastUsedAt,
+	}
+}
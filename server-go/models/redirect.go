@@ -0,0 +1,13 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// UsernameRedirect keeps an old handle pointing at its user for a grace
+// period after a username change.
+type UsernameRedirect struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Username  string             `bson:"username"`
+	User      primitive.ObjectID `bson:"user"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+	CreatedAt primitive.DateTime `bson:"createdAt"`
+}
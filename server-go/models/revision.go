@@ -0,0 +1,31 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// BentoRevision is a snapshot of a page. Writes by the same author within
+// BENTO_REVISION_WINDOW of a revision's creation update it in place, so
+// each one covers a short editing session; Version is the page version the
+// last of those writes produced. RestoredFrom is set when the write rolled
+// the page back to an earlier revision.
+type BentoRevision struct {
+	ID           primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Page         primitive.ObjectID     `bson:"page" json:"page"`
+	Slug         string                 `bson:"slug,omitempty" json:"slug"`
+	User         primitive.ObjectID     `bson:"user" json:"user"`
+	Author       primitive.ObjectID     `bson:"author" json:"author"`
+	Version      int64                  `bson:"version" json:"version"`
+	Widgets      []Widget               `bson:"widgets" json:"widgets"`
+	Layouts      map[string]interface{} `bson:"layouts" json:"layouts"`
+	RestoredFrom *primitive.ObjectID    `bson:"restored_from,omitempty" json:"restored_from,omitempty"`
+	CreatedAt    primitive.DateTime     `bson:"createdAt" json:"created_at"`
+}
+
+// RevisionSummary is a revision without its contents, for listings.
+type RevisionSummary struct {
+	ID           primitive.ObjectID  `bson:"_id" json:"id"`
+	Author       primitive.ObjectID  `bson:"author" json:"author"`
+	Version      int64               `bson:"version" json:"version"`
+	WidgetCount  int                 `bson:"widget_count" json:"widget_count"`
+	RestoredFrom *primitive.ObjectID `bson:"restored_from,omitempty" json:"restored_from,omitempty"`
+	CreatedAt    primitive.DateTime  `bson:"createdAt" json:"created_at"`
+}
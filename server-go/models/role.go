@@ -0,0 +1,69 @@
+package models
+
+// Roles, from least to most privileged.
+const (
+	RoleUser       = "user"
+	RoleModerator  = "moderator"
+	RoleSupport    = "support"
+	RoleSuperAdmin = "super-admin"
+)
+
+// Permissions checked by middleware.RequirePermission.
+const (
+	PermUsersRead      = "users:read"
+	PermUsersBlock     = "users:block"
+	PermSessionsRead   = "sessions:read"
+	PermSessionsRevoke = "sessions:revoke"
+	PermLockoutsRead   = "lockouts:read"
+	PermLockoutsClear  = "lockouts:clear"
+	PermRolesAssign    = "roles:assign"
+	PermAuditRead      = "audit:read"
+)
+
+// Roles lists every assignable role.
+var Roles = []string{RoleUser, RoleModerator, RoleSupport, RoleSuperAdmin}
+
+// RolePermissions is the permission set granted to each role. Plain users
+// get none; super-admins get everything.
+var RolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleModerator: {
+		PermUsersRead,
+		PermUsersBlock,
+		PermLockoutsRead,
+	},
+	RoleSupport: {
+		PermUsersRead,
+		PermSessionsRead,
+		PermSessionsRevoke,
+		PermLockoutsRead,
+		PermLockoutsClear,
+		PermAuditRead,
+	},
+	RoleSuperAdmin: {
+		PermUsersRead,
+		PermUsersBlock,
+		PermSessionsRead,
+		PermSessionsRevoke,
+		PermLockoutsRead,
+		PermLockoutsClear,
+		PermRolesAssign,
+		PermAuditRead,
+	},
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role, perm string) bool {
+	for _, granted := range RolePermissions[role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Session is a server-side login session backing a rotating refresh token.
+type Session struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	User            primitive.ObjectID  `bson:"user" json:"user"`
+	RefreshHash     string              `bson:"refresh_hash" json:"-"`
+	PrevRefreshHash string              `bson:"prev_refresh_hash,omitempty" json:"-"`
+	UserAgent       string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IPHash          string              `bson:"ip_hash,omitempty" json:"-"`
+	MFA             bool                `bson:"mfa" json:"mfa"`
+	CreatedAt       primitive.DateTime  `bson:"createdAt" json:"created_at"`
+	LastUsedAt      primitive.DateTime  `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt       primitive.DateTime  `bson:"expires_at" json:"expires_at"`
+	RevokedAt       *primitive.DateTime `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// SessionView is the shape returned by the session list endpoints.
+type SessionView struct {
+	ID         string             `json:"id"`
+	UserAgent  string             `json:"user_agent,omitempty"`
+	CreatedAt  primitive.DateTime `json:"created_at"`
+	LastUsedAt primitive.DateTime `json:"last_used_at"`
+	ExpiresAt  primitive.DateTime `json:"expires_at"`
+	Current    bool               `json:"current"`
+}
+
+func (s *Session) View(currentID primitive.ObjectID) SessionView {
+	return SessionView{
+		ID:         s.ID.Hex(),
+		UserAgent:  s.UserAgent,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+		Current:    s.ID == currentID,
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// SigningKey is one asymmetric JWT key. PrivateKey is PKCS#8 sealed with a
+// key derived from JWT_SECRET; PublicKey is PKIX DER. A key signs tokens
+// until RetireAt and is published in the JWKS until ExpiresAt, so tokens it
+// signed stay verifiable after rotation.
+type SigningKey struct {
+	ID         string             `bson:"_id"`
+	Algorithm  string             `bson:"alg"`
+	PrivateKey []byte             `bson:"private_key"`
+	PublicKey  []byte             `bson:"public_key"`
+	CreatedAt  primitive.DateTime `bson:"createdAt"`
+	RetireAt   primitive.DateTime `bson:"retire_at"`
+	ExpiresAt  primitive.DateTime `bson:"expires_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenEmailChange       = "email_change"
+)
+
+// ActionToken is a single-use, expiring token sent to a user by email.
+// Only the hash of the token is stored.
+type ActionToken struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty"`
+	User      primitive.ObjectID  `bson:"user"`
+	Purpose   string              `bson:"purpose"`
+	Hash      string              `bson:"hash"`
+	Email     string              `bson:"email"`
+	CreatedAt primitive.DateTime  `bson:"createdAt"`
+	ExpiresAt primitive.DateTime  `bson:"expires_at"`
+	UsedAt    *primitive.DateTime `bson:"used_at,omitempty"`
+}
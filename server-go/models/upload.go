@@ -0,0 +1,20 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	StorageLocal      = "local"
+	StorageCloudinary = "cloudinary"
+)
+
+// Upload records a file a user uploaded so it can be exported and cleaned
+// up with the account. Filename is the local file name or Cloudinary
+// public ID, depending on Storage.
+type Upload struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	User      primitive.ObjectID `bson:"user" json:"-"`
+	URL       string             `bson:"url" json:"url"`
+	Filename  string             `bson:"filename" json:"filename"`
+	Storage   string             `bson:"storage" json:"storage"`
+	CreatedAt primitive.DateTime `bson:"createdAt" json:"created_at"`
+}
@@ -3,66 +3,114 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Username  string             `bson:"username" json:"username"`
-	Email     string             `bson:"email" json:"email"`
-	Password  string             `bson:"password" json:"-"`
-	FullName  *string            `bson:"full_name,omitempty" json:"full_name,omitempty"`
-	AvatarURL *string            `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
-	Role      string             `bson:"role" json:"role"`
-	IsBlocked bool               `bson:"is_blocked" json:"is_blocked"`
-	CreatedAt *primitive.DateTime `bson:"createdAt,omitempty" json:"created_at,omitempty"`
-	UpdatedAt *primitive.DateTime `bson:"updatedAt,omitempty" json:"updated_at,omitempty"`
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Username      string              `bson:"username" json:"username"`
+	Email         string              `bson:"email" json:"email"`
+	Password      string              `bson:"password" json:"-"`
+	FullName      *string             `bson:"full_name,omitempty" json:"full_name,omitempty"`
+	AvatarURL     *string             `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+	Role          string              `bson:"role" json:"role"`
+	IsBlocked     bool                `bson:"is_blocked" json:"is_blocked"`
+	EmailVerified bool                `bson:"email_verified" json:"email_verified"`
+	TwoFactor     TwoFactor           `bson:"two_factor,omitempty" json:"-"`
+	Identities    []LinkedIdentity    `bson:"identities,omitempty" json:"-"`
+	Passkeys      []Passkey           `bson:"passkeys,omitempty" json:"-"`
+	DeleteAfter   *primitive.DateTime `bson:"delete_after,omitempty" json:"delete_after,omitempty"`
+	CreatedAt     *primitive.DateTime `bson:"createdAt,omitempty" json:"created_at,omitempty"`
+	UpdatedAt     *primitive.DateTime `bson:"updatedAt,omitempty" json:"updated_at,omitempty"`
 }
 
 func (u *User) Public() PublicUser {
 	return PublicUser{
-		ID:        u.ID.Hex(),
-		Username:  u.Username,
-		Email:     u.Email,
-		Role:      u.Role,
-		IsBlocked: u.IsBlocked,
-		FullName:  u.FullName,
-		AvatarURL: u.AvatarURL,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
+		ID:            u.ID.Hex(),
+		Username:      u.Username,
+		Email:         u.Email,
+		Role:          u.Role,
+		Permissions:   RolePermissions[u.Role],
+		IsBlocked:     u.IsBlocked,
+		EmailVerified: u.EmailVerified,
+		TwoFactor:     u.TwoFactor.Enabled,
+		Providers:     u.Providers(),
+		DeleteAfter:   u.DeleteAfter,
+		FullName:      u.FullName,
+		AvatarURL:     u.AvatarURL,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
 	}
 }
 
 func (u *User) Admin() AdminUser {
 	return AdminUser{
-		ID:        u.ID.Hex(),
-		Username:  u.Username,
-		Email:     u.Email,
-		Role:      u.Role,
-		IsBlocked: u.IsBlocked,
-		FullName:  u.FullName,
-		AvatarURL: u.AvatarURL,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
+		ID:            u.ID.Hex(),
+		Username:      u.Username,
+		Email:         u.Email,
+		Role:          u.Role,
+		IsBlocked:     u.IsBlocked,
+		EmailVerified: u.EmailVerified,
+		TwoFactor:     u.TwoFactor.Enabled,
+		DeleteAfter:   u.DeleteAfter,
+		FullName:      u.FullName,
+		AvatarURL:     u.AvatarURL,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
 	}
 }
 
 type PublicUser struct {
-	ID        string             `json:"id"`
-	Username  string             `json:"username"`
-	Email     string             `json:"email"`
-	Role      string             `json:"role"`
-	IsBlocked bool               `json:"is_blocked"`
-	FullName  *string            `json:"full_name,omitempty"`
-	AvatarURL *string            `json:"avatar_url,omitempty"`
-	CreatedAt *primitive.DateTime `json:"created_at,omitempty"`
-	UpdatedAt *primitive.DateTime `json:"updated_at,omitempty"`
+	ID            string              `json:"id"`
+	Username      string              `json:"username"`
+	Email         string              `json:"email"`
+	Role          string              `json:"role"`
+	Permissions   []string            `json:"permissions,omitempty"`
+	IsBlocked     bool                `json:"is_blocked"`
+	EmailVerified bool                `json:"email_verified"`
+	TwoFactor     bool                `json:"two_factor_enabled"`
+	Providers     []string            `json:"providers,omitempty"`
+	DeleteAfter   *primitive.DateTime `json:"delete_after,omitempty"`
+	FullName      *string             `json:"full_name,omitempty"`
+	AvatarURL     *string             `json:"avatar_url,omitempty"`
+	CreatedAt     *primitive.DateTime `json:"created_at,omitempty"`
+	UpdatedAt     *primitive.DateTime `json:"updated_at,omitempty"`
 }
 
 type AdminUser struct {
-	ID        string             `json:"id"`
-	Username  string             `json:"username"`
-	Email     string             `json:"email"`
-	Role      string             `json:"role"`
-	IsBlocked bool               `json:"is_blocked"`
-	FullName  *string            `json:"full_name,omitempty"`
-	AvatarURL *string            `json:"avatar_url,omitempty"`
-	CreatedAt *primitive.DateTime `json:"created_at,omitempty"`
-	UpdatedAt *primitive.DateTime `json:"updated_at,omitempty"`
+	ID            string              `json:"id"`
+	Username      string              `json:"username"`
+	Email         string              `json:"email"`
+	Role          string              `json:"role"`
+	IsBlocked     bool                `json:"is_blocked"`
+	EmailVerified bool                `json:"email_verified"`
+	TwoFactor     bool                `json:"two_factor_enabled"`
+	DeleteAfter   *primitive.DateTime `json:"delete_after,omitempty"`
+	FullName      *string             `json:"full_name,omitempty"`
+	AvatarURL     *string             `json:"avatar_url,omitempty"`
+	CreatedAt     *primitive.DateTime `json:"created_at,omitempty"`
+	UpdatedAt     *primitive.DateTime `json:"updated_at,omitempty"`
+}
+
+// TwoFactor holds a user's TOTP enrollment. PendingSecret is set between
+// setup and the first successful code; recovery codes are stored hashed.
+type TwoFactor struct {
+	Enabled       bool     `bson:"enabled"`
+	Secret        string   `bson:"secret,omitempty"`
+	PendingSecret string   `bson:"pending_secret,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
+	LastCounter   int64    `bson:"last_counter,omitempty"`
+}
+
+// LinkedIdentity ties the user to an account at a social login provider.
+type LinkedIdentity struct {
+	Provider string             `bson:"provider"`
+	Subject  string             `bson:"subject"`
+	Email    string             `bson:"email,omitempty"`
+	LinkedAt primitive.DateTime `bson:"linked_at"`
+}
+
+// Providers lists the social login providers linked to the user.
+func (u *User) Providers() []string {
+	providers := make([]string, 0, len(u.Identities))
+	for _, identity := range u.Identities {
+		providers = append(providers, identity.Provider)
+	}
+	return providers
 }
@@ -0,0 +1,115 @@
+package models
+
+import "time"
+
+// Widget types.
+const (
+	WidgetLink      = "link"
+	WidgetHeading   = "heading"
+	WidgetNote      = "note"
+	WidgetGallery   = "gallery"
+	WidgetVideo     = "video"
+	WidgetMap       = "map"
+	WidgetSocial    = "social"
+	WidgetCountdown = "countdown"
+)
+
+// Video providers accepted by video widgets.
+const (
+	VideoYouTube = "youtube"
+	VideoVimeo   = "vimeo"
+)
+
+// SocialNetworks lists the networks a social widget can link to.
+var SocialNetworks = []string{
+	"instagram", "x", "youtube", "tiktok", "linkedin", "github",
+	"facebook", "threads", "twitch", "discord", "website",
+}
+
+// HeadingWidget is a section title that splits a page into groups.
+type HeadingWidget struct {
+	Text     string `bson:"text" json:"text"`
+	Subtitle string `bson:"subtitle,omitempty" json:"subtitle,omitempty"`
+}
+
+// NoteWidget is a block of Markdown text.
+type NoteWidget struct {
+	Markdown string `bson:"markdown" json:"markdown"`
+}
+
+// GalleryWidget is a set of images shown as a carousel.
+type GalleryWidget struct {
+	Images []GalleryImage `bson:"images" json:"images"`
+}
+
+type GalleryImage struct {
+	URL     string `bson:"url" json:"url"`
+	Caption string `bson:"caption,omitempty" json:"caption,omitempty"`
+}
+
+// VideoWidget embeds a YouTube or Vimeo video. Provider and VideoID are
+// derived from URL on save; clients only need to send URL.
+type VideoWidget struct {
+	URL      string `bson:"url" json:"url"`
+	Provider string `bson:"provider" json:"provider"`
+	VideoID  string `bson:"video_id" json:"videoId"`
+}
+
+// MapWidget pins a location.
+type MapWidget struct {
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+	Zoom      int     `bson:"zoom,omitempty" json:"zoom,omitempty"`
+	Label     string  `bson:"label,omitempty" json:"label,omitempty"`
+}
+
+// SocialWidget is a row of profile icons.
+type SocialWidget struct {
+	Profiles []SocialProfile `bson:"profiles" json:"profiles"`
+}
+
+type SocialProfile struct {
+	Network string `bson:"network" json:"network"`
+	URL     string `bson:"url" json:"url"`
+}
+
+// CountdownWidget counts down to Target, then shows DoneText.
+type CountdownWidget struct {
+	Target   time.Time `bson:"target" json:"target"`
+	Label    string    `bson:"label,omitempty" json:"label,omitempty"`
+	DoneText string    `bson:"done_text,omitempty" json:"doneText,omitempty"`
+}
+
+// ValidSocialNetwork reports whether network is one of SocialNetworks.
+func ValidSocialNetwork(network string) bool {
+	for _, n := range SocialNetworks {
+		if n == network {
+			return true
+		}
+	}
+	return false
+}
+
+// WidgetSizes lists the grid footprints a widget can take, as
+// columns x rows.
+var WidgetSizes = []string{"1x1", "2x1", "1x2", "2x2", "3x1"}
+
+// ValidWidgetSize reports whether size is one of WidgetSizes.
+func ValidWidgetSize(size string) bool {
+	for _, s := range WidgetSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// LayoutBreakpoints lists the responsive grid breakpoints a page stores a
+// layout for, with the number of columns at each.
+var LayoutBreakpoints = map[string]int{
+	"lg":  4,
+	"md":  2,
+	"sm":  2,
+	"xs":  1,
+	"xxs": 1,
+}
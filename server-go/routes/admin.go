@@ -4,6 +4,7 @@ import (
 	"brolink-server/app"
 	"brolink-server/controllers"
 	"brolink-server/middleware"
+	"brolink-server/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -11,6 +12,18 @@ import (
 func RegisterAdmin(router fiber.Router, state *app.State) {
 	adminController := &controllers.AdminController{State: state}
 
-	router.Get("/admin/users", middleware.RequireAuth(state.Config), adminController.GetUsers)
-	router.Post("/admin/users/:id/block", middleware.RequireAuth(state.Config), adminController.BlockUser)
+	auth := middleware.RequireAuth(state)
+	can := func(perm string) fiber.Handler {
+		return middleware.RequirePermission(state, perm)
+	}
+
+	router.Get("/admin/roles", auth, can(models.PermUsersRead), adminController.GetRoles)
+	router.Get("/admin/users", auth, can(models.PermUsersRead), adminController.GetUsers)
+	router.Post("/admin/users/:id/block", auth, can(models.PermUsersBlock), adminController.BlockUser)
+	router.Put("/admin/users/:id/role", auth, can(models.PermRolesAssign), adminController.SetUserRole)
+	router.Get("/admin/users/:id/sessions", auth, can(models.PermSessionsRead), adminController.GetUserSessions)
+	router.Delete("/admin/users/:id/sessions", auth, can(models.PermSessionsRevoke), adminController.RevokeUserSessions)
+	router.Post("/admin/users/:id/unlock", auth, can(models.PermLockoutsClear), adminController.UnlockUser)
+	router.Get("/admin/lockouts", auth, can(models.PermLockoutsRead), adminController.GetLockouts)
+	router.Get("/admin/audit", auth, can(models.PermAuditRead), adminController.GetAudit)
 }
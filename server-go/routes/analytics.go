@@ -4,6 +4,7 @@ import (
 	"brolink-server/app"
 	"brolink-server/controllers"
 	"brolink-server/middleware"
+	"brolink-server/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -14,12 +15,15 @@ func RegisterAnalytics(router fiber.Router, state *app.State) {
 	// Public — records a click event
 	router.Post("/clicks", ac.RecordClick)
 
+	// Public — records a click and redirects to the widget's URL
+	router.Get("/r/:username/:widgetId", ac.RedirectLink)
+
 	// Auth-protected analytics endpoints
-	router.Get("/analytics", middleware.RequireAuth(state.Config), ac.GetAnalytics)
-	router.Get("/analytics/timeline", middleware.RequireAuth(state.Config), ac.GetTimeline)
-	router.Get("/analytics/referrers", middleware.RequireAuth(state.Config), ac.GetReferrers)
-	router.Get("/analytics/devices", middleware.RequireAuth(state.Config), ac.GetDevices)
-	router.Get("/analytics/geo", middleware.RequireAuth(state.Config), ac.GetGeo)
-	router.Get("/analytics/logs", middleware.RequireAuth(state.Config), ac.GetClickLogs)
-	router.Get("/analytics/locations", middleware.RequireAuth(state.Config), ac.GetLocations)
+	router.Get("/analytics", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetAnalytics)
+	router.Get("/analytics/timeline", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetTimeline)
+	router.Get("/analytics/referrers", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetReferrers)
+	router.Get("/analytics/devices", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetDevices)
+	router.Get("/analytics/geo", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetGeo)
+	router.Get("/analytics/logs", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetClickLogs)
+	router.Get("/analytics/locations", middleware.RequireAuth(state, models.ScopeAnalyticsRead), ac.GetLocations)
 }
@@ -4,6 +4,7 @@ import (
 	"brolink-server/app"
 	"brolink-server/controllers"
 	"brolink-server/middleware"
+	"brolink-server/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -13,5 +14,41 @@ func RegisterAuth(router fiber.Router, state *app.State) {
 
 	router.Post("/auth/signup", authController.Signup)
 	router.Post("/auth/login", authController.Login)
-	router.Get("/auth/me", middleware.RequireAuth(state.Config), authController.GetMe)
+	router.Post("/auth/login/2fa", authController.LoginTwoFactor)
+	router.Post("/auth/refresh", authController.Refresh)
+	router.Post("/auth/magic-link", authController.RequestMagicLink)
+	router.Post("/auth/magic-link/consume", authController.ConsumeMagicLink)
+	router.Get("/auth/oauth/providers", authController.ListOAuthProviders)
+	router.Post("/auth/oauth/exchange", authController.OAuthExchange)
+	router.Get("/auth/oauth/:provider", authController.OAuthStart)
+	router.Get("/auth/oauth/:provider/callback", authController.OAuthCallback)
+	router.Post("/auth/password/forgot", authController.ForgotPassword)
+	router.Post("/auth/password/reset", authController.ResetPassword)
+	router.Post("/auth/verify-email", authController.VerifyEmail)
+	router.Post("/auth/verify-email/resend", middleware.RequireAuth(state), authController.ResendVerification)
+	router.Get("/auth/me", middleware.RequireAuth(state, models.ScopeProfileRead), authController.GetMe)
+	router.Patch("/auth/me", middleware.RequireAuth(state), authController.UpdateMe)
+	router.Delete("/auth/me", middleware.RequireAuth(state), authController.DeleteMe)
+	router.Get("/auth/me/export", middleware.RequireAuth(state), authController.ExportMe)
+	router.Get("/auth/me/activity", middleware.RequireAuth(state), authController.GetActivity)
+	router.Post("/auth/me/email", middleware.RequireAuth(state), authController.ChangeEmail)
+	router.Post("/auth/me/email/confirm", authController.ConfirmEmailChange)
+	router.Post("/auth/logout", middleware.RequireAuth(state), authController.Logout)
+	router.Post("/auth/2fa/setup", middleware.RequireAuth(state), authController.SetupTwoFactor)
+	router.Post("/auth/2fa/enable", middleware.RequireAuth(state), authController.EnableTwoFactor)
+	router.Post("/auth/2fa/disable", middleware.RequireAuth(state), authController.DisableTwoFactor)
+	router.Post("/auth/2fa/recovery-codes", middleware.RequireAuth(state), authController.RegenerateRecoveryCodes)
+	router.Get("/auth/tokens", middleware.RequireAuth(state), authController.ListTokens)
+	router.Post("/auth/tokens", middleware.RequireAuth(state), authController.CreateToken)
+	router.Patch("/auth/tokens/:id", middleware.RequireAuth(state), authController.UpdateToken)
+	router.Delete("/auth/tokens/:id", middleware.RequireAuth(state), authController.DeleteToken)
+	router.Post("/auth/passkeys/login/begin", authController.BeginPasskeyLogin)
+	router.Post("/auth/passkeys/login/finish", authController.FinishPasskeyLogin)
+	router.Get("/auth/passkeys", middleware.RequireAuth(state), authController.ListPasskeys)
+	router.Post("/auth/passkeys/register/begin", middleware.RequireAuth(state), authController.BeginPasskeyRegistration)
+	router.Post("/auth/passkeys/register/finish", middleware.RequireAuth(state), authController.FinishPasskeyRegistration)
+	router.Patch("/auth/passkeys/:id", middleware.RequireAuth(state), authController.RenamePasskey)
+	router.Delete("/auth/passkeys/:id", middleware.RequireAuth(state), authController.DeletePasskey)
+	router.Get("/auth/sessions", middleware.RequireAuth(state), authController.GetSessions)
+	router.Delete("/auth/sessions/:id", middleware.RequireAuth(state), authController.RevokeSession)
 }
@@ -4,6 +4,7 @@ import (
 	"brolink-server/app"
 	"brolink-server/controllers"
 	"brolink-server/middleware"
+	"brolink-server/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -11,6 +12,22 @@ import (
 func RegisterBento(router fiber.Router, state *app.State) {
 	bentoController := &controllers.BentoController{State: state}
 
+	// Registered ahead of /bento/:username so they aren't read as a handle.
+	router.Get("/bento/pages", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.ListPages)
+	router.Post("/bento/pages", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.CreatePage)
+	router.Patch("/bento/pages/:slug", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.UpdatePage)
+	router.Delete("/bento/pages/:slug", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.DeletePage)
+	router.Get("/bento/draft", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.GetDraft)
+	router.Get("/bento/preview", bentoController.GetPreview)
+	router.Post("/bento/preview", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.CreatePreview)
+	router.Post("/bento/publish", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.PublishBento)
+	router.Delete("/bento/publish", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.CancelScheduledPublish)
+	router.Get("/bento/revisions", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.ListRevisions)
+	router.Get("/bento/revisions/:id", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.GetRevision)
+	router.Get("/bento/revisions/:id/diff", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.DiffRevision)
+	router.Post("/bento/revisions/:id/restore", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.RestoreRevision)
+
 	router.Get("/bento/:username", bentoController.GetBento)
-	router.Post("/bento/sync", middleware.RequireAuth(state.Config), bentoController.SyncBento)
+	router.Get("/bento/:username/:slug", bentoController.GetBento)
+	router.Post("/bento/sync", middleware.RequireAuth(state, models.ScopeBentoWrite), bentoController.SyncBento)
 }
@@ -4,6 +4,7 @@ import (
 	"brolink-server/app"
 	"brolink-server/controllers"
 	"brolink-server/middleware"
+	"brolink-server/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -14,6 +15,6 @@ func RegisterUpload(router fiber.Router, state *app.State, uploadsDir string) {
 		UploadsDir: uploadsDir,
 	}
 
-	router.Post("/upload", middleware.RequireAuth(state.Config), uploadController.UploadImage)
-	router.Post("/upload/delete", middleware.RequireAuth(state.Config), uploadController.DeleteImage)
+	router.Post("/upload", middleware.RequireAuth(state, models.ScopeMediaWrite), uploadController.UploadImage)
+	router.Post("/upload/delete", middleware.RequireAuth(state, models.ScopeMediaWrite), uploadController.DeleteImage)
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"brolink-server/app"
+	"brolink-server/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterWellKnown mounts the /.well-known documents at the site root.
+func RegisterWellKnown(router fiber.Router, state *app.State) {
+	keysController := &controllers.KeysController{State: state}
+
+	router.Get("/.well-known/jwks.json", keysController.JWKS)
+}
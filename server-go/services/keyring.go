@@ -0,0 +1,406 @@
+package services
+
+import (
+	"brolink-server/config"
+	"brolink-server/models"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	keyRefreshInterval = 10 * time.Minute
+	keyReloadCooldown  = 5 * time.Second
+	// keyPublishMargin keeps a retired key in the JWKS a little longer than
+	// the last token it signed, for verifiers with stale caches.
+	keyPublishMargin = time.Hour
+)
+
+// JWK is one public key in JSON Web Key form.
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyRing signs and verifies access tokens. With HS256 it simply wraps
+// JWT_SECRET. With RS256 or EdDSA it keeps key pairs in Mongo, so every
+// instance signs with the same current key, and rotates them every
+// JWT_KEY_ROTATION. Retired keys stay verifiable until the tokens they
+// signed have expired.
+type KeyRing struct {
+	alg      string
+	secret   []byte
+	issuer   string
+	rotation time.Duration
+	overlap  time.Duration
+	coll     *mongo.Collection
+	sealKey  []byte
+
+	mu       sync.RWMutex
+	keys     map[string]*ringKey
+	current  *ringKey
+	loadedAt time.Time
+}
+
+type ringKey struct {
+	id       string
+	private  crypto.Signer
+	public   crypto.PublicKey
+	retireAt time.Time
+}
+
+// NewKeyRing loads the signing keys for cfg.JWTAlgorithm, creating the first
+// one if there is none yet.
+func NewKeyRing(ctx context.Context, cfg *config.Config, coll *mongo.Collection) (*KeyRing, error) {
+	seal := sha256.Sum256([]byte("brolink-jwt-keys:" + cfg.JWTSecret))
+	k := &KeyRing{
+		alg:      cfg.JWTAlgorithm,
+		secret:   []byte(cfg.JWTSecret),
+		issuer:   cfg.ServerURL,
+		rotation: cfg.JWTKeyRotation,
+		overlap:  cfg.AccessTokenTTL + keyPublishMargin,
+		coll:     coll,
+		sealKey:  seal[:],
+		keys:     map[string]*ringKey{},
+	}
+	if k.symmetric() {
+		return k, nil
+	}
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := k.rotate(ctx); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Issuer is the iss claim on every access token.
+func (k *KeyRing) Issuer() string {
+	return k.issuer
+}
+
+// Run reloads keys written by other instances and rotates the current key
+// when it is due, until ctx is cancelled.
+func (k *KeyRing) Run(ctx context.Context) {
+	if k.symmetric() {
+		return
+	}
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		tickCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := k.refresh(tickCtx); err != nil {
+			log.Printf("jwt keys: refresh: %v", err)
+		} else if _, err := k.rotate(tickCtx); err != nil {
+			log.Printf("jwt keys: rotate: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Sign returns the signed token for claims, with the current key's kid in
+// its header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	if k.symmetric() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+
+	k.mu.RLock()
+	key := k.current
+	k.mu.RUnlock()
+	if key == nil || !time.Now().Before(key.retireAt) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var err error
+		if key, err = k.rotate(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.alg), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resolves the verification key for jwt.Parse. Tokens must use the
+// configured algorithm; an unknown kid triggers a reload in case another
+// instance has just rotated.
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if k.symmetric() {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return k.secret, nil
+	}
+	if token.Method.Alg() != k.alg {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key := k.lookup(kid); key != nil {
+		return key.public, nil
+	}
+
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > keyReloadCooldown
+	k.mu.RUnlock()
+	if stale {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+		if key := k.lookup(kid); key != nil {
+			return key.public, nil
+		}
+	}
+	return nil, jwt.ErrSignatureInvalid
+}
+
+// JWKS returns the public halves of every key that may still have live
+// tokens. It is empty in HS256 mode.
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.id, Alg: k.alg, Use: "sig"}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (k *KeyRing) symmetric() bool {
+	return k.alg == config.JWTAlgHS256
+}
+
+func (k *KeyRing) lookup(kid string) *ringKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[kid]
+}
+
+// refresh reloads every unexpired key for the configured algorithm.
+func (k *KeyRing) refresh(ctx context.Context) error {
+	now := time.Now()
+	cursor, err := k.coll.Find(ctx, bson.M{
+		"alg":        k.alg,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(now)},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []models.SigningKey
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*ringKey, len(docs))
+	var current *ringKey
+	for _, doc := range docs {
+		key, err := k.decode(doc)
+		if err != nil {
+			// Usually means JWT_SECRET changed; the key can't be used any
+			// more and a fresh one will be generated.
+			log.Printf("jwt keys: skipping %s: %v", doc.ID, err)
+			continue
+		}
+		keys[key.id] = key
+		if now.Before(key.retireAt) && (current == nil || key.retireAt.After(current.retireAt)) {
+			current = key
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.current = current
+	k.loadedAt = now
+	k.mu.Unlock()
+	return nil
+}
+
+// rotate generates a new key when there is no current one, and returns the
+// key to sign with. That is the key it made rather than whatever is current
+// afterwards, since a concurrent refresh that read the collection before
+// the insert may leave no current key behind.
+func (k *KeyRing) rotate(ctx context.Context) (*ringKey, error) {
+	k.mu.RLock()
+	current := k.current
+	k.mu.RUnlock()
+	if current != nil && time.Now().Before(current.retireAt) {
+		return current, nil
+	}
+
+	doc, err := k.generate()
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.decode(*doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.coll.InsertOne(ctx, doc); err != nil {
+		return nil, err
+	}
+	log.Printf("jwt keys: rotated to %s", doc.ID)
+	if err := k.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *KeyRing) generate() (*models.SigningKey, error) {
+	var private crypto.Signer
+	switch k.alg {
+	case config.JWTAlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case config.JWTAlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", k.alg)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := k.seal(privDER)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	retire := now.Add(k.rotation)
+	return &models.SigningKey{
+		ID:         hex.EncodeToString(id),
+		Algorithm:  k.alg,
+		PrivateKey: sealed,
+		PublicKey:  pubDER,
+		CreatedAt:  primitive.NewDateTimeFromTime(now),
+		RetireAt:   primitive.NewDateTimeFromTime(retire),
+		ExpiresAt:  primitive.NewDateTimeFromTime(retire.Add(k.overlap)),
+	}, nil
+}
+
+func (k *KeyRing) decode(doc models.SigningKey) (*ringKey, error) {
+	privDER, err := k.open(doc.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(privDER)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	public, err := x509.ParsePKIXPublicKey(doc.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ringKey{
+		id:       doc.ID,
+		private:  private,
+		public:   public,
+		retireAt: doc.RetireAt.Time(),
+	}, nil
+}
+
+func (k *KeyRing) seal(plain []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (k *KeyRing) open(sealed []byte) ([]byte, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed key too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func (k *KeyRing) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
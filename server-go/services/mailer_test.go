@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalMailerWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	m := &LocalMailer{Dir: dir, From: "BroLink <no-reply@example.com>"}
+	err := m.Send(context.Background(), MailMessage{
+		To:      "ada@example.com",
+		Subject: "Reset your password",
+		Body:    "https://example.com/reset?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*_ada_at_example.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want one .eml for the recipient, got %v (%v)", files, err)
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"From: BroLink <no-reply@example.com>\r\n",
+		"To: ada@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"\r\n\r\nhttps://example.com/reset?token=abc",
+	} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("message missing %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestLocalMailerCancelled(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m := &LocalMailer{Dir: dir, From: "no-reply@example.com"}
+	if err := m.Send(ctx, MailMessage{To: "ada@example.com"}); err == nil {
+		t.Fatal("Send on a cancelled context succeeded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("cancelled send wrote %d files", len(entries))
+	}
+}
+
+// TestSMTPMailerEnvelopeSender checks that MAIL FROM carries the bare
+// address even when MAIL_FROM has a display name.
+func TestSMTPMailerEnvelopeSender(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	commands := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			commands <- nil
+			return
+		}
+		defer conn.Close()
+		commands <- serveSMTP(conn)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	m := &SMTPMailer{Host: host, Port: port, From: "BroLink <no-reply@example.com>"}
+	if err := m.Send(context.Background(), MailMessage{To: "ada@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := <-commands
+	var mailFrom string
+	for _, cmd := range got {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			mailFrom = cmd
+		}
+	}
+	if !strings.HasPrefix(mailFrom, "MAIL FROM:<no-reply@example.com>") {
+		t.Fatalf("envelope sender = %q", mailFrom)
+	}
+}
+
+// serveSMTP plays just enough of an SMTP server for one message and
+// returns the commands it was sent.
+func serveSMTP(conn net.Conn) []string {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	var cmds []string
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return cmds
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmds = append(cmds, line)
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return cmds
+				}
+				if data == ".\r\n" {
+					break
+				}
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return cmds
+		default:
+			reply("250 ok")
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"brolink-server/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OAuthIdentity is what a provider tells us about the person signing in.
+type OAuthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+	Picture       string
+}
+
+type OAuthError struct {
+	Message string
+}
+
+func (e OAuthError) Error() string {
+	return e.Message
+}
+
+// OAuthClient runs the authorization-code flow with PKCE against one
+// configured provider.
+type OAuthClient struct {
+	Provider    config.OAuthProvider
+	RedirectURL string
+	HTTP        *http.Client
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jwksCacheEntry struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	discoveryCache sync.Map // issuer -> *oidcDiscovery
+	jwksCache      sync.Map // jwks uri -> *jwksCacheEntry
+)
+
+const jwksMaxAge = time.Hour
+
+func NewOAuthClient(provider config.OAuthProvider, redirectURL string) *OAuthClient {
+	return &OAuthClient{
+		Provider:    provider,
+		RedirectURL: redirectURL,
+		HTTP:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewPKCE returns a code verifier and its S256 challenge.
+func NewPKCE() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL builds the provider URL the browser is sent to.
+func (o *OAuthClient) AuthCodeURL(ctx context.Context, state, challenge, nonce string) (string, error) {
+	endpoint := "https://github.com/login/oauth/authorize"
+	if o.Provider.Kind == "oidc" {
+		disc, err := o.discover(ctx)
+		if err != nil {
+			return "", err
+		}
+		endpoint = disc.AuthorizationEndpoint
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", o.Provider.ClientID)
+	params.Set("redirect_uri", o.RedirectURL)
+	params.Set("scope", strings.Join(o.Provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", challenge)
+	params.Set("code_challenge_method", "S256")
+	if o.Provider.Kind == "oidc" {
+		params.Set("nonce", nonce)
+	}
+	return endpoint + "?" + params.Encode(), nil
+}
+
+type oauthTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+// Identify exchanges the authorization code and resolves the signed-in
+// identity. For OIDC providers the ID token signature, issuer, audience
+// and nonce are all checked.
+func (o *OAuthClient) Identify(ctx context.Context, code, verifier, nonce string) (*OAuthIdentity, error) {
+	tokenURL := "https://github.com/login/oauth/access_token"
+	var disc *oidcDiscovery
+	if o.Provider.Kind == "oidc" {
+		var err error
+		if disc, err = o.discover(ctx); err != nil {
+			return nil, err
+		}
+		tokenURL = disc.TokenEndpoint
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.RedirectURL)
+	form.Set("client_id", o.Provider.ClientID)
+	form.Set("client_secret", o.Provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	var tokens oauthTokens
+	if err := o.doJSON(request, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.Error != "" {
+		return nil, OAuthError{Message: fmt.Sprintf("token exchange failed: %s %s", tokens.Error, tokens.ErrorDesc)}
+	}
+
+	if o.Provider.Kind == "github" {
+		return o.githubIdentity(ctx, tokens.AccessToken)
+	}
+	return o.oidcIdentity(ctx, disc, tokens, nonce)
+}
+
+func (o *OAuthClient) oidcIdentity(ctx context.Context, disc *oidcDiscovery, tokens oauthTokens, nonce string) (*OAuthIdentity, error) {
+	if tokens.IDToken == "" {
+		return nil, OAuthError{Message: "provider returned no id_token"}
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokens.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.verificationKey(ctx, disc.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(disc.Issuer),
+		jwt.WithAudience(o.Provider.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, OAuthError{Message: fmt.Sprintf("invalid id_token: %v", err)}
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, OAuthError{Message: "id_token nonce mismatch"}
+	}
+
+	identity := &OAuthIdentity{
+		Subject:       claimString(claims, "sub"),
+		Email:         claimString(claims, "email"),
+		EmailVerified: claimBool(claims, "email_verified"),
+		Name:          claimString(claims, "name"),
+		Username:      claimString(claims, "preferred_username"),
+		Picture:       claimString(claims, "picture"),
+	}
+	if identity.Subject == "" {
+		return nil, OAuthError{Message: "id_token has no subject"}
+	}
+
+	// Some providers leave profile claims out of the ID token.
+	if identity.Email == "" && disc.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, disc.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		info := map[string]interface{}{}
+		if err := o.doJSON(request, &info); err != nil {
+			return nil, err
+		}
+		if claimString(info, "sub") != identity.Subject {
+			return nil, OAuthError{Message: "userinfo subject mismatch"}
+		}
+		identity.Email = claimString(info, "email")
+		identity.EmailVerified = claimBool(info, "email_verified")
+		if identity.Name == "" {
+			identity.Name = claimString(info, "name")
+		}
+		if identity.Picture == "" {
+			identity.Picture = claimString(info, "picture")
+		}
+	}
+	return identity, nil
+}
+
+func (o *OAuthClient) githubIdentity(ctx context.Context, accessToken string) (*OAuthIdentity, error) {
+	var profile struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := o.githubGet(ctx, accessToken, "https://api.github.com/user", &profile); err != nil {
+		return nil, err
+	}
+	if profile.ID == 0 {
+		return nil, OAuthError{Message: "github returned no user id"}
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := o.githubGet(ctx, accessToken, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &OAuthIdentity{
+		Subject:  strconv.FormatInt(profile.ID, 10),
+		Name:     profile.Name,
+		Username: profile.Login,
+		Picture:  profile.AvatarURL,
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+			break
+		}
+	}
+	return identity, nil
+}
+
+func (o *OAuthClient) githubGet(ctx context.Context, accessToken, target string, dest interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Accept", "application/vnd.github+json")
+	return o.doJSON(request, dest)
+}
+
+func (o *OAuthClient) discover(ctx context.Context) (*oidcDiscovery, error) {
+	issuer := strings.TrimRight(o.Provider.Issuer, "/")
+	if cached, ok := discoveryCache.Load(issuer); ok {
+		return cached.(*oidcDiscovery), nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var disc oidcDiscovery
+	if err := o.doJSON(request, &disc); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(disc.Issuer, "/") != issuer {
+		return nil, OAuthError{Message: "discovery issuer mismatch"}
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, OAuthError{Message: "incomplete discovery document"}
+	}
+	discoveryCache.Store(issuer, &disc)
+	return &disc, nil
+}
+
+// verificationKey finds kid in the provider's JWKS, refetching the set when
+// the key is unknown or the cache is stale.
+func (o *OAuthClient) verificationKey(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	if cached, ok := jwksCache.Load(jwksURI); ok {
+		entry := cached.(*jwksCacheEntry)
+		if key, found := entry.keys[kid]; found && time.Since(entry.fetchedAt) < jwksMaxAge {
+			return key, nil
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.doJSON(request, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	jwksCache.Store(jwksURI, &jwksCacheEntry{keys: keys, fetchedAt: time.Now()})
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, OAuthError{Message: "unknown signing key"}
+	}
+	return key, nil
+}
+
+func (o *OAuthClient) doJSON(request *http.Request, dest interface{}) error {
+	response, err := o.HTTP.Do(request)
+	if err != nil {
+		return OAuthError{Message: fmt.Sprintf("request failed: %v", err)}
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return OAuthError{Message: fmt.Sprintf("%s returned status %d", request.URL.Host, response.StatusCode)}
+	}
+	if err := json.NewDecoder(response.Body).Decode(dest); err != nil {
+		return OAuthError{Message: fmt.Sprintf("decode failed: %v", err)}
+	}
+	return nil
+}
+
+func claimString(claims map[string]interface{}, key string) string {
+	if v, ok := claims[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// claimBool tolerates providers that send email_verified as a string.
+func claimBool(claims map[string]interface{}, key string) bool {
+	switch v := claims[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"brolink-server/config"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+	// bcryptMaxBytes is where bcrypt stops reading the password.
+	bcryptMaxBytes = 72
+)
+
+// PasswordPolicyError is a password the policy rejects. Its message is safe
+// to show to the user.
+type PasswordPolicyError struct {
+	msg string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.msg
+}
+
+// Passwords hashes and verifies account passwords and enforces the password
+// policy. New hashes use PASSWORD_HASH; Verify accepts bcrypt and argon2id
+// hashes alike and reports when a stored hash should be upgraded.
+type Passwords struct {
+	algorithm  string
+	bcryptCost int
+	argon      argon2Params
+	minLength  int
+	maxLength  int
+	breachList string
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// NewPasswords builds the hasher and policy from cfg. It fails if the breach
+// list is configured but can't be opened.
+func NewPasswords(cfg *config.Config) (*Passwords, error) {
+	p := &Passwords{
+		algorithm:  cfg.PasswordHash,
+		bcryptCost: cfg.BcryptCost,
+		argon: argon2Params{
+			memory:  uint32(cfg.Argon2Memory),
+			time:    uint32(cfg.Argon2Time),
+			threads: uint8(cfg.Argon2Threads),
+		},
+		minLength:  cfg.PasswordMinLength,
+		maxLength:  cfg.PasswordMaxLength,
+		breachList: cfg.PasswordBreachList,
+	}
+	if p.breachList != "" {
+		file, err := os.Open(p.breachList)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+	}
+	return p, nil
+}
+
+// Check applies the password policy to a new password. It returns a
+// *PasswordPolicyError when the password is rejected.
+func (p *Passwords) Check(password string) error {
+	if strings.TrimSpace(password) == "" {
+		return &PasswordPolicyError{"password is required"}
+	}
+	if utf8.RuneCountInString(password) < p.minLength {
+		return &PasswordPolicyError{fmt.Sprintf("password must be at least %d characters", p.minLength)}
+	}
+	if utf8.RuneCountInString(password) > p.maxLength {
+		return &PasswordPolicyError{fmt.Sprintf("password must be at most %d characters", p.maxLength)}
+	}
+	if p.algorithm == config.PasswordHashBcrypt && len(password) > bcryptMaxBytes {
+		return &PasswordPolicyError{"password is too long"}
+	}
+	if p.breached(password) {
+		return &PasswordPolicyError{"password has appeared in a data breach; choose another"}
+	}
+	return nil
+}
+
+// Hash returns the stored form of password using the configured algorithm.
+func (p *Passwords) Hash(password string) (string, error) {
+	if p.algorithm == config.PasswordHashArgon2id {
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.argon.time, p.argon.memory, p.argon.threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.argon.memory, p.argon.time, p.argon.threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), p.bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Verify reports whether password matches hash, and whether hash was made
+// with an older algorithm or weaker parameters than the current settings.
+func (p *Passwords) Verify(hash, password string) (ok, rehash bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		return true, p.algorithm != config.PasswordHashArgon2id || params != p.argon
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	if p.algorithm != config.PasswordHashBcrypt {
+		return true, true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err == nil && cost < p.bcryptCost
+}
+
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, err
+	}
+	// argon2.IDKey panics on these, so a bad stored hash must not reach it.
+	if params.time < 1 || params.threads < 1 || params.memory < 8*uint32(params.threads) {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+	return params, salt, key, nil
+}
+
+// breached looks the password up in PASSWORD_BREACH_LIST, a Pwned Passwords
+// style file of upper-case SHA-1 hashes sorted by hash, one per line with an
+// optional ":count" suffix. The file is binary searched in place, so the full
+// multi-gigabyte list works without loading it. Lookup errors fail open.
+func (p *Passwords) breached(password string) bool {
+	if p.breachList == "" {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	target := []byte(strings.ToUpper(hex.EncodeToString(sum[:])))
+
+	file, err := os.Open(p.breachList)
+	if err != nil {
+		log.Printf("password breach list: %v", err)
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("password breach list: %v", err)
+		return false
+	}
+
+	// Find the first line starting at or after lo whose hash is >= target.
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hash, err := breachLineAfter(file, mid)
+		if err != nil && err != io.EOF {
+			log.Printf("password breach list: %v", err)
+			return false
+		}
+		if hash != nil && bytes.Compare(hash, target) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	hash, err := breachLineAfter(file, lo)
+	if err != nil && err != io.EOF {
+		log.Printf("password breach list: %v", err)
+		return false
+	}
+	return bytes.Equal(hash, target)
+}
+
+// breachLineAfter returns the hash on the first line that begins at or after
+// offset, skipping the partial line offset lands in.
+func breachLineAfter(file *os.File, offset int64) ([]byte, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, start, 1<<62))
+	if offset > 0 {
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			return nil, err
+		}
+	}
+	line, err := reader.ReadBytes('\n')
+	if len(line) == 0 {
+		return nil, err
+	}
+	hash, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+	return bytes.ToUpper(hash), nil
+}